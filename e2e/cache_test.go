@@ -0,0 +1,95 @@
+package main_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestObjectCache(t *testing.T) {
+	id := generateID()
+
+	get := func() string {
+		t.Helper()
+
+		resp, err := httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d for GET, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return string(body)
+	}
+
+	for _, body := range []string{generateBody(), generateBody()} { // overwrite must not serve cached body
+		resp, err := httpPutObject(id, body)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+
+		for i := 0; i < 2; i++ {
+			if got := get(); got != body {
+				t.Errorf("Expected body %q, got %q", body, got)
+			}
+		}
+	}
+
+	{ // Range is served from cached object
+		req, _ := http.NewRequest(http.MethodGet, baseUrl+id, nil)
+		req.Header.Set("Range", "bytes=0-3")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusPartialContent || string(body) != "body" {
+			t.Errorf("Expected partial body %q, got %d %q", "body", resp.StatusCode, body)
+		}
+	}
+
+	{ // Deleted object is not served from cache
+		resp, err := httpPutObject(id, "") // zero-length PUT deletes object
+		if err != nil {
+			t.Fatalf("Failed to delete object: %v", err)
+		}
+		resp.Body.Close()
+
+		resp, err = httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d for deleted object, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+
+	{ // Cache exports metrics
+		resp, err := httpDo(http.MethodGet, "http://localhost:3000/metrics", "")
+		if err != nil {
+			t.Fatalf("Failed to GET metrics: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		for _, metric := range []string{"gateway_object_cache_hits_total", "gateway_object_cache_misses_total"} {
+			if !strings.Contains(string(body), metric) {
+				t.Errorf("Expected metric %q to be exported", metric)
+			}
+		}
+	}
+}
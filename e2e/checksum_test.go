@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func TestObjectChecksums(t *testing.T) {
+	id := generateID()
+	body := generateBody()
+
+	sha := sha256.Sum256([]byte(body))
+	shaHeader := base64.StdEncoding.EncodeToString(sha[:])
+
+	put := func(id string, header, value string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPut, baseUrl+id, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		req.Header.Set(header, value)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+
+		return resp
+	}
+
+	{ // Matching checksum is stored
+		resp := put(id, "X-Amz-Checksum-Sha256", shaHeader)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	{ // Stored checksum is returned
+		resp, err := httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if got := resp.Header.Get("X-Amz-Checksum-Sha256"); got != shaHeader {
+			t.Errorf("Expected checksum %q, got %q", shaHeader, got)
+		}
+	}
+
+	mismatchID := generateID()
+
+	{ // Mismatching Content-MD5 is rejected
+		sum := md5.Sum([]byte("something else"))
+
+		resp := put(mismatchID, "Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d for PUT, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	{ // Malformed checksum is rejected
+		resp := put(mismatchID, "X-Amz-Checksum-Crc32c", "not-base64")
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d for PUT, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	{ // Rejected object is not stored
+		resp, err := httpGetObject(mismatchID)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d for GET, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+}
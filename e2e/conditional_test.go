@@ -0,0 +1,101 @@
+package main_test
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func httpPutObjectIf(id, body, header, etag string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPut, baseUrl+id, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(header, etag)
+
+	return client.Do(req)
+}
+
+func TestConditionalWrites(t *testing.T) {
+	id := generateID()
+
+	put := func(body, header, etag string, expected int) string {
+		t.Helper()
+
+		resp, err := httpPutObjectIf(id, body, header, etag)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Fatalf("Expected status %d for PUT with %s %s, got %d", expected, header, etag, resp.StatusCode)
+		}
+
+		return resp.Header.Get("ETag")
+	}
+
+	first := put(generateBody(), "If-None-Match", "*", http.StatusCreated)
+	put(generateBody(), "If-None-Match", "*", http.StatusPreconditionFailed)
+
+	put(generateBody(), "If-Match", `"0123456789abcdef0123456789abcdef"`, http.StatusPreconditionFailed)
+	second := put(generateBody(), "If-Match", first, http.StatusCreated)
+	put(generateBody(), "If-Match", first, http.StatusPreconditionFailed) // stale after swap
+
+	put("", "If-Match", first, http.StatusPreconditionFailed) // zero-length PUT deletes
+	put("", "If-Match", second, http.StatusNoContent)
+
+	resp, err := httpGetObject(id)
+	if err != nil {
+		t.Fatalf("Failed to GET object: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d after conditional delete, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	put(generateBody(), "If-Match", "*", http.StatusPreconditionFailed)
+}
+
+func TestConditionalCreateRace(t *testing.T) {
+	id := generateID()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resp, err := httpPutObjectIf(id, generateBody(), "If-None-Match", "*")
+			if err != nil {
+				t.Errorf("Failed to PUT object: %v", err)
+
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode == http.StatusCreated {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else if resp.StatusCode != http.StatusPreconditionFailed {
+				t.Errorf("Expected status %d or %d, got %d", http.StatusCreated, http.StatusPreconditionFailed, resp.StatusCode)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("Expected exactly one create-only PUT to succeed, got %d", created)
+	}
+}
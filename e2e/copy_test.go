@@ -0,0 +1,153 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestObjectCopyAndMove(t *testing.T) {
+	for name, size := range map[string]int{
+		"small":   1 << 10,
+		"striped": 40 << 20, // manifest objects are streamed, parts are never shared
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := make([]byte, size)
+			if _, err := rand.Read(body); err != nil {
+				t.Fatalf("Failed to generate body: %v", err)
+			}
+
+			sum := sha256.Sum256(body)
+			checksum := base64.StdEncoding.EncodeToString(sum[:])
+
+			src, copied, moved := generateID(), generateID(), generateID()
+
+			req, err := http.NewRequest(http.MethodPut, baseUrl+src, bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+
+			req.Header.Set("X-Amz-Checksum-Sha256", checksum)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to PUT object: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+			}
+
+			post := func(url string) {
+				t.Helper()
+
+				resp, err := httpDo(http.MethodPost, url, "")
+				if err != nil {
+					t.Fatalf("Failed to POST %s: %v", url, err)
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusCreated {
+					t.Fatalf("Expected status %d for POST %s, got %d", http.StatusCreated, url, resp.StatusCode)
+				}
+			}
+
+			expectObject := func(id string) {
+				t.Helper()
+
+				resp, err := httpGetObject(id)
+				if err != nil {
+					t.Fatalf("Failed to GET object: %v", err)
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("Expected status %d for GET, got %d", http.StatusOK, resp.StatusCode)
+				}
+
+				data, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatalf("Failed to read body: %v", err)
+				}
+
+				if !bytes.Equal(data, body) {
+					t.Errorf("Expected object %q to match source body", id)
+				}
+
+				if v := resp.Header.Get("X-Amz-Checksum-Sha256"); v != checksum {
+					t.Errorf("Expected checksum %q of object %q, got %q", checksum, id, v)
+				}
+			}
+
+			expectMissing := func(id string) {
+				t.Helper()
+
+				resp, err := httpGetObject(id)
+				if err != nil {
+					t.Fatalf("Failed to GET object: %v", err)
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("Expected status %d for GET of %q, got %d", http.StatusNotFound, id, resp.StatusCode)
+				}
+			}
+
+			post(baseUrl + src + "/copy?to=" + copied)
+			expectObject(src)
+			expectObject(copied)
+
+			post(baseUrl + copied + "/move?to=" + moved)
+			expectMissing(copied)
+			expectObject(moved)
+		})
+	}
+}
+
+func TestObjectCopyErrors(t *testing.T) {
+	src, dst := generateID(), generateID()
+
+	for _, id := range []string{src, dst} {
+		resp, err := httpPutObject(id, generateBody())
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	for _, tc := range []struct {
+		name     string
+		url      string
+		header   string
+		expected int
+	}{
+		{"to itself", baseUrl + src + "/copy?to=" + src, "", http.StatusBadRequest},
+		{"invalid destination", baseUrl + src + "/copy?to=invalid-id[***]", "", http.StatusBadRequest},
+		{"missing source", baseUrl + generateID() + "/move?to=" + generateID(), "", http.StatusNotFound},
+		{"existing destination", baseUrl + src + "/copy?to=" + dst, "*", http.StatusPreconditionFailed},
+	} {
+		req, err := http.NewRequest(http.MethodPost, tc.url, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		if tc.header != "" {
+			req.Header.Set("If-None-Match", tc.header)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to POST copy %s: %v", tc.name, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.expected {
+			t.Errorf("Expected status %d for copy %s, got %d", tc.expected, tc.name, resp.StatusCode)
+		}
+	}
+}
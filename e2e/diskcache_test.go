@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestLargeObjectReread(t *testing.T) {
+	id := generateID()
+
+	put := func(body []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPut, baseUrl+id, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	get := func(rangeHeader string) []byte {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, baseUrl+id, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read body: %v", err)
+		}
+
+		return body
+	}
+
+	for i := 0; i < 2; i++ { // second version must not be served from cached first version
+		body := make([]byte, 4<<20) // above in-memory cache limit
+		if _, err := rand.Read(body); err != nil {
+			t.Fatalf("Failed to generate body: %v", err)
+		}
+
+		put(body)
+
+		for j := 0; j < 2; j++ { // first read fills cache, second is served from it
+			if got := get(""); !bytes.Equal(got, body) {
+				t.Fatalf("Body mismatch on read %d of version %d, got %d bytes", j, i, len(got))
+			}
+		}
+
+		if got := get("bytes=1048570-1048585"); !bytes.Equal(got, body[1048570:1048586]) {
+			t.Errorf("Range mismatch for version %d, got %d bytes", i, len(got))
+		}
+	}
+}
@@ -241,3 +241,50 @@ func TestInvalidID(t *testing.T) {
 		t.Errorf("Expected status %d for invalid ID, got %d", http.StatusBadRequest, resp.StatusCode)
 	}
 }
+
+func BenchmarkObjectPut(b *testing.B) {
+	body := generateBody()
+
+	for i := 0; i < b.N; i++ {
+		resp, err := httpPutObject(generateID(), body)
+		if err != nil {
+			b.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			b.Errorf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+}
+
+func BenchmarkObjectGet(b *testing.B) {
+	body := generateBody()
+	ids := make([]string, b.N)
+
+	for i := range ids { // every object is read once, so caches can not hide backend round-trips
+		ids[i] = generateID()
+
+		resp, err := httpPutObject(ids[i], body)
+		if err != nil {
+			b.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	b.ResetTimer()
+
+	for _, id := range ids {
+		resp, err := httpGetObject(id)
+		if err != nil {
+			b.Fatalf("Failed to GET object: %v", err)
+		}
+
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err != nil || resp.StatusCode != http.StatusOK {
+			b.Errorf("Expected status %d for GET, got %d (%v)", http.StatusOK, resp.StatusCode, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectExpiry(t *testing.T) {
+	id := generateID()
+
+	resp, err := httpPutObjectIf(id, generateBody(), "X-Object-Expires", "2")
+	if err != nil {
+		t.Fatalf("Failed to PUT object: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err = httpGetObject(id)
+	if err != nil {
+		t.Fatalf("Failed to GET object: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d before expiry, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if _, err := http.ParseTime(resp.Header.Get("X-Object-Expires")); err != nil {
+		t.Errorf("Expected X-Object-Expires date, got %q", resp.Header.Get("X-Object-Expires"))
+	}
+
+	time.Sleep(3 * time.Second) // sweeper runs far less often, object must be hidden anyway
+
+	resp, err = httpGetObject(id)
+	if err != nil {
+		t.Fatalf("Failed to GET object: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d after expiry, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = httpDo(http.MethodGet, strings.TrimSuffix(baseUrl, "/")+"?prefix="+id, "")
+	if err != nil {
+		t.Fatalf("Failed to list objects: %v", err)
+	}
+	defer resp.Body.Close()
+
+	listing, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read listing: %v", err)
+	}
+
+	if strings.Contains(string(listing), id) {
+		t.Errorf("Expected expired object to be hidden from listing, got %q", listing)
+	}
+}
+
+func TestObjectExpiryInvalid(t *testing.T) {
+	for _, value := range []string{"soon", "0", "-5"} {
+		resp, err := httpPutObjectIf(generateID(), generateBody(), "X-Object-Expires", value)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d for X-Object-Expires %q, got %d", http.StatusBadRequest, value, resp.StatusCode)
+		}
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"crypto/rand"
 	"math/big"
 	"net/http"
+	"strings"
 	"time"
 )
 
-const baseUrl = "http://localhost:3000/object/"
+const (
+	baseUrl   = "http://localhost:3000/object/"
+	nsBaseUrl = "http://localhost:3000/ns/"
+)
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
@@ -52,3 +56,16 @@ func httpGetObject(id string) (*http.Response, error) {
 
 	return client.Do(req)
 }
+
+func httpDo(method, url string, body string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Do(req)
+}
+
+func generateNamespace() string {
+	return "e2e-" + strings.ToLower(generateID()[:16])
+}
@@ -0,0 +1,68 @@
+package main_test
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHierarchicalKeys(t *testing.T) {
+	dir := generateID()
+	ids := []string{
+		dir + "/a.txt",
+		dir + "/sub/b.json",
+		dir + "/sub/c-1_2.log",
+	}
+
+	for _, id := range ids { // Create objects
+		resp, err := httpPutObject(id, generateBody())
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status %d for PUT %s, got %d", http.StatusCreated, id, resp.StatusCode)
+		}
+	}
+
+	{ // Get object with escaped slashes
+		resp, err := httpGetObject(url.PathEscape(ids[1]))
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d for GET, got %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	{ // List "directory"
+		resp, err := httpDo(http.MethodGet, strings.TrimSuffix(baseUrl, "/")+"?delimiter=/&prefix="+url.QueryEscape(dir+"/"), "")
+		if err != nil {
+			t.Fatalf("Failed to list objects: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		expected := dir + "/a.txt " + dir + "/sub/"
+		if string(responseBody) != expected {
+			t.Errorf("Expected listing %q, got %q", expected, string(responseBody))
+		}
+	}
+
+	for _, id := range ids { // Delete objects
+		resp, err := httpPutObject(id, "")
+		if err != nil {
+			t.Fatalf("Failed to DELETE object: %v", err)
+		}
+		defer resp.Body.Close()
+	}
+}
@@ -0,0 +1,285 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	id := generateID()
+	parts := []string{generateBody(), generateBody(), generateBody()}
+
+	var uploadID string
+
+	{ // Initiate upload
+		resp, err := httpDo(http.MethodPost, baseUrl+id+"?uploads", "")
+		if err != nil {
+			t.Fatalf("Failed to initiate upload: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d for initiate, got %d", http.StatusCreated, resp.StatusCode)
+		}
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		uploadID = strings.TrimSpace(string(responseBody))
+	}
+
+	for i := len(parts) - 1; i >= 0; i-- { // Upload parts out of order
+		resp, err := httpDo(http.MethodPut, baseUrl+id+"?uploadId="+uploadID+"&partNumber="+string(rune('1'+i)), parts[i])
+		if err != nil {
+			t.Fatalf("Failed to upload part: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d for part %d, got %d", http.StatusOK, i+1, resp.StatusCode)
+		}
+	}
+
+	{ // Object is not visible before completion
+		resp, err := httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d before completion, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+
+	{ // Complete upload with all parts
+		resp, err := httpDo(http.MethodPost, baseUrl+id+"?uploadId="+uploadID, "")
+		if err != nil {
+			t.Fatalf("Failed to complete upload: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status %d for complete, got %d", http.StatusCreated, resp.StatusCode)
+		}
+
+		if etag := resp.Header.Get("ETag"); !strings.HasSuffix(etag, `-3"`) {
+			t.Errorf("Expected multipart ETag, got %q", etag)
+		}
+	}
+
+	{ // Get assembled object
+		resp, err := httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if string(responseBody) != strings.Join(parts, "") {
+			t.Errorf("Expected assembled body, got %q", string(responseBody))
+		}
+	}
+
+	{ // Range spanning part boundary
+		req, err := http.NewRequest(http.MethodGet, baseUrl+id, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		req.Header.Set("Range", "bytes=30-45")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if resp.StatusCode != http.StatusPartialContent || string(responseBody) != strings.Join(parts, "")[30:46] {
+			t.Errorf("Expected partial content, got %d %q", resp.StatusCode, string(responseBody))
+		}
+	}
+
+	{ // Completed upload is gone
+		resp, err := httpDo(http.MethodDelete, baseUrl+id+"?uploadId="+uploadID, "")
+		if err != nil {
+			t.Fatalf("Failed to abort upload: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d for abort after completion, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+}
+
+func TestS3APIMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	c := newS3Client(t)
+	core := minio.Core{Client: c}
+
+	bucket := generateNamespace()
+	key := generateID() + "/large.bin"
+
+	if err := c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	uploadID, err := core.NewMultipartUpload(ctx, bucket, key, minio.PutObjectOptions{ContentType: "application/x-test"})
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	bodies := [][]byte{bytes.Repeat([]byte("a"), 5<<20), []byte(generateBody())}
+	completeParts := make([]minio.CompletePart, 0, len(bodies))
+
+	for i, body := range bodies {
+		part, err := core.PutObjectPart(ctx, bucket, key, uploadID, i+1, bytes.NewReader(body), int64(len(body)), minio.PutObjectPartOptions{})
+		if err != nil {
+			t.Fatalf("Failed to upload part %d: %v", i+1, err)
+		}
+
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+
+	{ // Abort unknown upload
+		err := core.AbortMultipartUpload(ctx, bucket, key, strings.Repeat("0", 32))
+		if minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+			t.Errorf("Expected NoSuchUpload, got %v", err)
+		}
+	}
+
+	if _, err := core.CompleteMultipartUpload(ctx, bucket, key, uploadID, completeParts, minio.PutObjectOptions{}); err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+
+	info, err := c.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatalf("Failed to stat object: %v", err)
+	}
+
+	expectedSize := int64(len(bodies[0]) + len(bodies[1]))
+	if info.Size != expectedSize || info.ContentType != "application/x-test" {
+		t.Errorf("Expected size %d and content type, got %d %q", expectedSize, info.Size, info.ContentType)
+	}
+
+	object, err := c.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get object: %v", err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("Failed to read object: %v", err)
+	}
+
+	if !bytes.Equal(data, bytes.Join(bodies, nil)) {
+		t.Errorf("Expected assembled object of %d bytes, got %d bytes", expectedSize, len(data))
+	}
+
+	if err := c.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		t.Errorf("Failed to remove object: %v", err)
+	}
+
+	if err := c.RemoveBucket(ctx, bucket); err != nil {
+		t.Errorf("Failed to remove bucket: %v", err)
+	}
+}
+
+func TestMultipartCompleteTwice(t *testing.T) {
+	id := generateID()
+	parts := []string{generateBody(), generateBody()}
+
+	resp, err := httpDo(http.MethodPost, baseUrl+id+"?uploads", "")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for initiate, got %d: %v", http.StatusCreated, resp.StatusCode, err)
+	}
+
+	uploadID := strings.TrimSpace(string(responseBody))
+
+	for i, part := range parts {
+		resp, err := httpDo(http.MethodPut, baseUrl+id+"?uploadId="+uploadID+"&partNumber="+string(rune('1'+i)), part)
+		if err != nil {
+			t.Fatalf("Failed to upload part: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	complete := func() int {
+		resp, err := httpDo(http.MethodPost, baseUrl+id+"?uploadId="+uploadID, "")
+		if err != nil {
+			t.Errorf("Failed to complete upload: %v", err)
+
+			return 0
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	statuses := make(chan int, 2)
+	for i := 0; i < 2; i++ { // racing completions, e.g. client retry after timeout
+		go func() {
+			statuses <- complete()
+		}()
+	}
+
+	created := 0
+	for i := 0; i < 2; i++ {
+		switch status := <-statuses; status {
+		case http.StatusCreated:
+			created++
+		case http.StatusNotFound:
+		default:
+			t.Errorf("Expected status %d or %d for complete, got %d", http.StatusCreated, http.StatusNotFound, status)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("Expected exactly one completion to succeed, got %d", created)
+	}
+
+	if status := complete(); status != http.StatusNotFound {
+		t.Errorf("Expected status %d for retried complete, got %d", http.StatusNotFound, status)
+	}
+
+	resp, err = httpGetObject(id)
+	if err != nil {
+		t.Fatalf("Failed to GET object: %v", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read object: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || string(responseBody) != strings.Join(parts, "") {
+		t.Errorf("Expected assembled body after repeated completion, got %d %q", resp.StatusCode, string(responseBody))
+	}
+}
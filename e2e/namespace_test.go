@@ -0,0 +1,114 @@
+package main_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceLifecycle(t *testing.T) {
+	ns := generateNamespace()
+	id := generateID()
+	body := generateBody()
+
+	{ // Create namespace
+		resp, err := httpDo(http.MethodPut, nsBaseUrl+ns, "")
+		if err != nil {
+			t.Fatalf("Failed to create namespace: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status %d for namespace create, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	{ // List namespaces
+		resp, err := httpDo(http.MethodGet, strings.TrimSuffix(nsBaseUrl, "/"), "")
+		if err != nil {
+			t.Fatalf("Failed to list namespaces: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if !strings.Contains(string(responseBody), ns) {
+			t.Errorf("Expected namespace %s in list, got %s", ns, string(responseBody))
+		}
+	}
+
+	{ // Create object in namespace
+		resp, err := httpDo(http.MethodPut, nsBaseUrl+ns+"/object/"+id, body)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	{ // Object is not visible in default namespace
+		resp, err := httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d in default namespace, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+
+	{ // Get object from namespace
+		resp, err := httpDo(http.MethodGet, nsBaseUrl+ns+"/object/"+id, "")
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if string(responseBody) != body {
+			t.Errorf("Expected body %s, got %s", body, string(responseBody))
+		}
+	}
+
+	{ // Non-empty namespace can not be deleted
+		resp, err := httpDo(http.MethodDelete, nsBaseUrl+ns, "")
+		if err != nil {
+			t.Fatalf("Failed to delete namespace: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected status %d for non-empty namespace delete, got %d", http.StatusConflict, resp.StatusCode)
+		}
+	}
+
+	{ // Delete object and namespace
+		resp, err := httpDo(http.MethodPut, nsBaseUrl+ns+"/object/"+id, "")
+		if err != nil {
+			t.Fatalf("Failed to DELETE object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		resp, err = httpDo(http.MethodDelete, nsBaseUrl+ns, "")
+		if err != nil {
+			t.Fatalf("Failed to delete namespace: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected status %d for namespace delete, got %d", http.StatusNoContent, resp.StatusCode)
+		}
+	}
+}
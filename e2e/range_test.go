@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestObjectGetRanges(t *testing.T) {
+	for _, size := range []int{64, 2 << 20} { // small objects are cached after first whole read
+		id := generateID()
+
+		body := make([]byte, size)
+		if _, err := rand.Read(body); err != nil {
+			t.Fatalf("Failed to generate body: %v", err)
+		}
+
+		req, err := http.NewRequest(http.MethodPut, baseUrl+id, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		resp.Body.Close()
+
+		for _, tc := range []struct {
+			rangeHeader  string
+			status       int
+			contentRange string
+			body         []byte
+		}{
+			{"bytes=10-19", http.StatusPartialContent, fmt.Sprintf("bytes 10-19/%d", size), body[10:20]},
+			{"bytes=-5", http.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size), body[size-5:]},
+			{"bytes=20-", http.StatusPartialContent, fmt.Sprintf("bytes 20-%d/%d", size-1, size), body[20:]},
+			{fmt.Sprintf("bytes=%d-", size), http.StatusRequestedRangeNotSatisfiable, "", nil},
+			{"", http.StatusOK, "", body},
+			{"bytes=10-19", http.StatusPartialContent, fmt.Sprintf("bytes 10-19/%d", size), body[10:20]},
+		} {
+			req, err := http.NewRequest(http.MethodGet, baseUrl+id, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to GET object: %v", err)
+			}
+
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+
+			if resp.StatusCode != tc.status {
+				t.Errorf("Expected status %d for range %q of %d bytes, got %d", tc.status, tc.rangeHeader, size, resp.StatusCode)
+
+				continue
+			}
+
+			if tc.body == nil {
+				continue
+			}
+
+			if cr := resp.Header.Get("Content-Range"); cr != tc.contentRange {
+				t.Errorf("Expected Content-Range %q, got %q", tc.contentRange, cr)
+			}
+
+			if !bytes.Equal(got, tc.body) {
+				t.Errorf("Body mismatch for range %q of %d bytes, got %d bytes", tc.rangeHeader, size, len(got))
+			}
+		}
+	}
+
+	{ // Missing object is reported before any body is sent
+		resp, err := httpGetObject(generateID())
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const s3Endpoint = "localhost:3001"
+
+func newS3Client(t *testing.T) *minio.Client {
+	t.Helper()
+
+	c, err := minio.New(s3Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4("", "", ""), // anonymous, authentication is disabled by default
+		Secure:       false,
+		BucketLookup: minio.BucketLookupPath,
+		Region:       "us-east-1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create S3 client: %v", err)
+	}
+
+	return c
+}
+
+func TestS3APIObjectLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := newS3Client(t)
+
+	bucket := generateNamespace()
+	dir := generateID()
+	body := []byte(generateBody())
+
+	if err := c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	keys := []string{dir + "/a.txt", dir + "/b.txt", dir + "/sub/c.txt"}
+
+	for _, key := range keys {
+		_, err := c.PutObject(ctx, bucket, key, bytes.NewReader(body), int64(len(body)),
+			minio.PutObjectOptions{
+				ContentType:  "text/plain",
+				UserMetadata: map[string]string{"Team": "e2e"},
+			},
+		)
+		if err != nil {
+			t.Fatalf("Failed to put object %s: %v", key, err)
+		}
+	}
+
+	{ // Stat object
+		info, err := c.StatObject(ctx, bucket, keys[0], minio.StatObjectOptions{})
+		if err != nil {
+			t.Fatalf("Failed to stat object: %v", err)
+		}
+
+		if info.Size != int64(len(body)) || info.ContentType != "text/plain" || info.UserMetadata["Team"] != "e2e" {
+			t.Errorf("Unexpected object info: %+v", info)
+		}
+	}
+
+	{ // Ranged get
+		opts := minio.GetObjectOptions{}
+		_ = opts.SetRange(5, 9)
+
+		obj, err := c.GetObject(ctx, bucket, keys[0], opts)
+		if err != nil {
+			t.Fatalf("Failed to get object: %v", err)
+		}
+		defer obj.Close()
+
+		got, err := io.ReadAll(obj)
+		if err != nil {
+			t.Fatalf("Failed to read object: %v", err)
+		}
+
+		if !bytes.Equal(got, body[5:10]) {
+			t.Errorf("Expected range %q, got %q", body[5:10], got)
+		}
+	}
+
+	{ // List with delimiter
+		var objects, prefixes []string
+
+		for object := range c.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: dir + "/", MaxKeys: 1}) {
+			if object.Err != nil {
+				t.Fatalf("Failed to list objects: %v", object.Err)
+			}
+
+			if object.Size == 0 && object.ETag == "" {
+				prefixes = append(prefixes, object.Key)
+			} else {
+				objects = append(objects, object.Key)
+			}
+		}
+
+		if len(objects) != 2 || len(prefixes) != 1 || prefixes[0] != dir+"/sub/" {
+			t.Errorf("Unexpected listing, objects %v, prefixes %v", objects, prefixes)
+		}
+	}
+
+	{ // Missing key
+		_, err := c.StatObject(ctx, bucket, dir+"/missing", minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			t.Errorf("Expected NoSuchKey, got %v", err)
+		}
+	}
+
+	{ // Bulk delete
+		objectsCh := make(chan minio.ObjectInfo, len(keys))
+		for _, key := range keys {
+			objectsCh <- minio.ObjectInfo{Key: key}
+		}
+		close(objectsCh)
+
+		for res := range c.RemoveObjects(ctx, bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+			t.Errorf("Failed to remove object %s: %v", res.ObjectName, res.Err)
+		}
+	}
+
+	if err := c.RemoveBucket(ctx, bucket); err != nil {
+		t.Errorf("Failed to remove bucket: %v", err)
+	}
+}
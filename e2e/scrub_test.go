@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const adminBaseUrl = "http://localhost:3000/admin/"
+
+type scrubReport struct {
+	Running        bool      `json:"running"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	ObjectsScanned int64     `json:"objectsScanned"`
+}
+
+func TestScrubber(t *testing.T) {
+	resp, err := httpPutObject(generateID(), generateBody())
+	if err != nil {
+		t.Fatalf("Failed to PUT object: %v", err)
+	}
+	resp.Body.Close()
+
+	requested := time.Now()
+
+	{ // Run is started on demand
+		resp, err := httpDo(http.MethodPost, adminBaseUrl+"scrub", "")
+		if err != nil {
+			t.Fatalf("Failed to start scrub: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusConflict {
+			t.Fatalf("Expected status %d or %d for scrub start, got %d",
+				http.StatusAccepted, http.StatusConflict, resp.StatusCode)
+		}
+	}
+
+	var report scrubReport
+
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(200 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Scrub run did not finish, last report: %+v", report)
+		}
+
+		resp, err := httpDo(http.MethodGet, adminBaseUrl+"scrub", "")
+		if err != nil {
+			t.Fatalf("Failed to GET scrub report: %v", err)
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to decode scrub report: %v", err)
+		}
+
+		if !report.Running && report.FinishedAt.After(requested) {
+			break
+		}
+	}
+
+	if report.ObjectsScanned == 0 {
+		t.Errorf("Expected scrub run to check stored objects")
+	}
+
+	{ // Scrubber exports metrics
+		resp, err := httpDo(http.MethodGet, "http://localhost:3000/metrics", "")
+		if err != nil {
+			t.Fatalf("Failed to GET metrics: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		if !strings.Contains(string(body), "gateway_scrub_objects_scanned_total") {
+			t.Errorf("Expected scrub metrics to be exported")
+		}
+	}
+}
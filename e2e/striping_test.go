@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestLargeObjectStriping(t *testing.T) {
+	id := generateID()
+
+	body := make([]byte, 40<<20) // above striping threshold
+	if _, err := rand.Read(body); err != nil {
+		t.Fatalf("Failed to generate body: %v", err)
+	}
+
+	{ // Create object
+		req, err := http.NewRequest(http.MethodPut, baseUrl+id, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to PUT object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	{ // Get whole object
+		resp, err := httpGetObject(id)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if !bytes.Equal(responseBody, body) {
+			t.Errorf("Expected reassembled body of %d bytes, got %d bytes", len(body), len(responseBody))
+		}
+	}
+
+	{ // Range spanning chunk boundary
+		req, err := http.NewRequest(http.MethodGet, baseUrl+id, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+
+		req.Header.Set("Range", "bytes=8388000-8389000")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to GET object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(responseBody, body[8388000:8389001]) {
+			t.Errorf("Expected partial content, got %d with %d bytes", resp.StatusCode, len(responseBody))
+		}
+	}
+
+	{ // Delete object
+		resp, err := httpPutObject(id, "")
+		if err != nil {
+			t.Fatalf("Failed to delete object: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected status %d for delete, got %d", http.StatusNoContent, resp.StatusCode)
+		}
+	}
+}
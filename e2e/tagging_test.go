@@ -0,0 +1,127 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestObjectTagging(t *testing.T) {
+	id := generateID()
+
+	resp, err := httpPutObject(id, generateBody())
+	if err != nil {
+		t.Fatalf("Failed to PUT object: %v", err)
+	}
+	resp.Body.Close()
+
+	putTags := func(tags map[string]string, expected int) {
+		t.Helper()
+
+		body, _ := json.Marshal(tags)
+
+		resp, err := httpDo(http.MethodPut, baseUrl+id+"/tags", string(body))
+		if err != nil {
+			t.Fatalf("Failed to PUT tags: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Expected status %d for PUT of %d tags, got %d", expected, len(tags), resp.StatusCode)
+		}
+	}
+
+	getTags := func() map[string]string {
+		t.Helper()
+
+		resp, err := httpDo(http.MethodGet, baseUrl+id+"/tags", "")
+		if err != nil {
+			t.Fatalf("Failed to GET tags: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d for GET tags, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		var tags map[string]string
+
+		err = json.NewDecoder(resp.Body).Decode(&tags)
+		if err != nil {
+			t.Fatalf("Failed to decode tags: %v", err)
+		}
+
+		return tags
+	}
+
+	listed := func(filter string) bool {
+		t.Helper()
+
+		resp, err := httpDo(http.MethodGet, strings.TrimSuffix(baseUrl, "/")+"?prefix="+id+"&tag="+url.QueryEscape(filter), "")
+		if err != nil {
+			t.Fatalf("Failed to list objects: %v", err)
+		}
+		defer resp.Body.Close()
+
+		listing, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read listing: %v", err)
+		}
+
+		return strings.Contains(string(listing), id)
+	}
+
+	putTags(map[string]string{"team": "infra", "build": "42"}, http.StatusNoContent)
+
+	if tags := getTags(); len(tags) != 2 || tags["team"] != "infra" || tags["build"] != "42" {
+		t.Errorf("Expected stored tags, got %v", tags)
+	}
+
+	for filter, expected := range map[string]bool{
+		"team=infra": true,
+		"team=web":   false,
+		"build":      true,
+		"retention":  false,
+	} {
+		if listed(filter) != expected {
+			t.Errorf("Expected listing filtered by %q to include object: %v", filter, expected)
+		}
+	}
+
+	tooMany := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	putTags(tooMany, http.StatusBadRequest)
+	putTags(map[string]string{strings.Repeat("k", 129): "value"}, http.StatusBadRequest)
+	putTags(map[string]string{"team": strings.Repeat("v", 257)}, http.StatusBadRequest)
+
+	resp, err = httpDo(http.MethodDelete, baseUrl+id+"/tags", "")
+	if err != nil {
+		t.Fatalf("Failed to DELETE tags: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d for DELETE tags, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	if tags := getTags(); len(tags) != 0 {
+		t.Errorf("Expected no tags after DELETE, got %v", tags)
+	}
+
+	resp, err = httpDo(http.MethodGet, baseUrl+generateID()+"/tags", "")
+	if err != nil {
+		t.Fatalf("Failed to GET tags: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for tags of missing object, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
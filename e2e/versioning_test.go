@@ -0,0 +1,249 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVersioningDisabledNamespace(t *testing.T) {
+	id := generateID()
+
+	resp, err := httpPutObject(id, generateBody())
+	if err != nil {
+		t.Fatalf("Failed to PUT object: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	if v := resp.Header.Get("X-Amz-Version-Id"); v != "" {
+		t.Errorf("Expected no version ID for unversioned namespace, got %q", v)
+	}
+
+	for _, tc := range []struct {
+		method, url string
+	}{
+		{http.MethodGet, baseUrl + id + "?versionId=someversion"},
+		{http.MethodGet, baseUrl + id + "/versions"},
+		{http.MethodPost, baseUrl + id + "/restore?versionId=someversion"},
+	} {
+		resp, err := httpDo(tc.method, tc.url, "")
+		if err != nil {
+			t.Fatalf("Failed to %s %s: %v", tc.method, tc.url, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s %s, got %d", http.StatusBadRequest, tc.method, tc.url, resp.StatusCode)
+		}
+	}
+}
+
+const versionedNamespace = "versioned" // configured with versioning in e2e/namespaces.json, mounted by docker-compose
+
+type objectVersion struct {
+	VersionID      string `json:"versionId"`
+	IsLatest       bool   `json:"isLatest"`
+	IsDeleteMarker bool   `json:"isDeleteMarker"`
+	Size           int64  `json:"size"`
+}
+
+func versionedDo(t *testing.T, method, path string, body []byte, headers ...string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, nsBaseUrl+versionedNamespace+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response of %s %s: %v", method, path, err)
+	}
+
+	return resp, data
+}
+
+func createVersionedNamespace(t *testing.T) {
+	t.Helper()
+
+	resp, _ := versionedDo(t, http.MethodPut, "", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for namespace create, got %d", http.StatusCreated, resp.StatusCode)
+	}
+}
+
+func TestVersioning(t *testing.T) {
+	createVersionedNamespace(t)
+
+	id := generateID()
+	path := "/object/" + id
+
+	first := make([]byte, 40<<20) // striped, its parts must outlive overwrite
+	if _, err := rand.Read(first); err != nil {
+		t.Fatalf("Failed to generate body: %v", err)
+	}
+
+	second := []byte(generateBody())
+
+	put := func(body []byte) string {
+		t.Helper()
+
+		resp, _ := versionedDo(t, http.MethodPut, path, body)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+		}
+
+		v := resp.Header.Get("X-Amz-Version-Id")
+		if v == "" {
+			t.Fatalf("Expected version ID for versioned namespace")
+		}
+
+		return v
+	}
+
+	expectBody := func(query string, expected []byte) {
+		t.Helper()
+
+		resp, data := versionedDo(t, http.MethodGet, path+query, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d for GET %s, got %d", http.StatusOK, query, resp.StatusCode)
+		}
+
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Expected body of GET %s to match version, got %d bytes", query, len(data))
+		}
+	}
+
+	listVersions := func() []objectVersion {
+		t.Helper()
+
+		resp, data := versionedDo(t, http.MethodGet, path+"/versions", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d for versions, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		var versions []objectVersion
+
+		err := json.Unmarshal(data, &versions)
+		if err != nil {
+			t.Fatalf("Failed to decode versions: %v", err)
+		}
+
+		return versions
+	}
+
+	v1 := put(first)
+	v2 := put(second)
+
+	if v1 == v2 {
+		t.Fatalf("Expected distinct version IDs, got %q twice", v1)
+	}
+
+	expectBody("", second)
+	expectBody("?versionId="+v1, first)
+	expectBody("?versionId="+v2, second)
+
+	versions := listVersions()
+	if len(versions) != 2 || versions[0].VersionID != v2 || !versions[0].IsLatest || versions[1].VersionID != v1 || versions[1].IsLatest {
+		t.Fatalf("Expected versions %q and %q newest first, got %+v", v2, v1, versions)
+	}
+
+	if versions[1].Size != int64(len(first)) {
+		t.Errorf("Expected size %d of noncurrent version, got %d", len(first), versions[1].Size)
+	}
+
+	resp, _ := versionedDo(t, http.MethodPut, path, nil) // empty body deletes, leaving delete marker
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d for DELETE, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, _ = versionedDo(t, http.MethodGet, path, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for GET after DELETE, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	versions = listVersions()
+	if len(versions) != 3 || !versions[0].IsDeleteMarker || !versions[0].IsLatest {
+		t.Fatalf("Expected delete marker as latest of 3 versions, got %+v", versions)
+	}
+
+	expectBody("?versionId="+v1, first)
+
+	resp, _ = versionedDo(t, http.MethodGet, path+"?versionId="+versions[0].VersionID, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for GET of delete marker, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, _ = versionedDo(t, http.MethodPost, path+"/restore?versionId="+versions[0].VersionID, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for restore of delete marker, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, _ = versionedDo(t, http.MethodPost, path+"/restore?versionId="+v1, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for restore, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	if v := resp.Header.Get("X-Amz-Version-Id"); v == "" || v == v1 {
+		t.Errorf("Expected restore to create new version, got %q", v)
+	}
+
+	expectBody("", first)
+
+	if versions = listVersions(); len(versions) != 4 {
+		t.Errorf("Expected restore to keep history of 4 versions, got %+v", versions)
+	}
+}
+
+func TestVersioningRestoreExpired(t *testing.T) { // explicit expiry of old version is not carried over
+	createVersionedNamespace(t)
+
+	path := "/object/" + generateID()
+	body := []byte(generateBody())
+
+	resp, _ := versionedDo(t, http.MethodPut, path, body, "X-Object-Expires", "1")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for PUT, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	version := resp.Header.Get("X-Amz-Version-Id")
+
+	time.Sleep(2 * time.Second)
+
+	resp, _ = versionedDo(t, http.MethodGet, path, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status %d after expiry, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, _ = versionedDo(t, http.MethodPost, path+"/restore?versionId="+version, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d for restore, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, data := versionedDo(t, http.MethodGet, path, nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(data, body) {
+		t.Errorf("Expected restored object, got %d %q", resp.StatusCode, data)
+	}
+
+	if v := resp.Header.Get("X-Object-Expires"); v != "" {
+		t.Errorf("Expected restored object without expiry, got %q", v)
+	}
+}
@@ -2,54 +2,444 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/gorilla/mux"
 
 	"code.local/homework-object-storage/s3gw"
 )
 
+const (
+	exitCodeOK      = 0
+	exitCodeFailure = 1
+)
+
 func init() { // KISS configuration
 	os.Setenv(s3gw.S3ContainerNamePatternEnvKey, "amazin-object-storage-node")
 	os.Setenv(s3gw.S3APIPortEnvKey, "9000")
+	os.Setenv(s3gw.S3BackendTLSEnvKey, "false") // internal network, customer keys (SSE-C) need TLS
+	os.Setenv(s3gw.S3BackendCAFileEnvKey, "")
+	os.Setenv(s3gw.S3BackendServerNameEnvKey, "") // empty verifies backend certificates against dialed address
 
 	os.Setenv(s3gw.ConsistentHashPartitionCountEnvKey, "71")
 	os.Setenv(s3gw.ConsistentHashReplicationFactorEnvKey, "20")
 	os.Setenv(s3gw.ConsistentHashLoadEnvKey, "1.25")
 
 	os.Setenv(s3gw.S3DefaultBucketNameEnvKey, "objects")
+	os.Setenv(s3gw.S3NamespaceBucketPrefixEnvKey, "ns-")
+	os.Setenv(s3gw.S3InternalBucketNameEnvKey, "gateway-internal") // multipart parts, chunks and upload state
+
+	os.Setenv(s3gw.ChunkedStorageThresholdEnvKey, "33554432") // 32 MiB, larger objects are striped across backends
+	os.Setenv(s3gw.ChunkSizeEnvKey, "8388608")                // 8 MiB
+	os.Setenv(s3gw.ChunkPrefetchEnvKey, "4")
+
+	os.Setenv(s3gw.MultipartUploadTTLEnvKey, "24h") // abandoned uploads are removed after TTL
+	os.Setenv(s3gw.MultipartGCIntervalEnvKey, "1h")
+
+	os.Setenv(s3gw.ChecksumVerifyOnReadEnvKey, "false") // verify whole object reads, result is sent as response trailer
+
+	if _, ok := os.LookupEnv(s3gw.NamespaceConfigFileEnvKey); !ok { // deployment may mount its own policy file
+		os.Setenv(s3gw.NamespaceConfigFileEnvKey, "") // per-namespace storage policy, empty keeps defaults
+	}
+
+	os.Setenv(s3gw.ErasureRepairIntervalEnvKey, "10m")
+	os.Setenv(s3gw.DedupGCIntervalEnvKey, "1h")
+	os.Setenv(s3gw.DedupGCGraceEnvKey, "1h")
+	os.Setenv(s3gw.ExpirySweepIntervalEnvKey, "10m") // expired objects are hidden before sweep
+
+	os.Setenv(s3gw.EncryptionMasterKeyFileEnvKey, "") // namespaces with envelope encryption need key file or KMS
+	os.Setenv(s3gw.EncryptionKMSEndpointEnvKey, "")
+	os.Setenv(s3gw.EncryptionKMSKeyEnvKey, "")
+
+	os.Setenv(s3gw.ObjectCacheSizeEnvKey, "268435456")        // 256 MiB, 0 disables in-memory read cache
+	os.Setenv(s3gw.ObjectCacheMaxObjectSizeEnvKey, "1048576") // 1 MiB
+	os.Setenv(s3gw.ObjectCacheTTLEnvKey, "30s")
+
+	os.Setenv(s3gw.DiskCacheDirEnvKey, "")                  // empty disables disk cache for large objects
+	os.Setenv(s3gw.DiskCacheSizeEnvKey, "10737418240")      // 10 GiB
+	os.Setenv(s3gw.DiskCacheMinObjectSizeEnvKey, "1048577") // smaller objects are kept in memory
+	os.Setenv(s3gw.DiskCacheEvictionEnvKey, "lru")
+
+	os.Setenv(s3gw.ScrubIntervalEnvKey, "24h") // 0 runs scrubber only on POST /admin/scrub
+	os.Setenv(s3gw.ScrubRateLimitEnvKey, "200")
+	os.Setenv(s3gw.ScrubAutoFixEnvKey, "false")
+	os.Setenv(s3gw.ScrubVerifyDataEnvKey, "false")
+
+	os.Setenv(s3gw.ObjectKeyPolicyEnvKey, "s3-safe") // preset (strict, uuid, s3-safe) or regular expression
+
+	os.Setenv(s3gw.HTTPListenAddressEnvKey, ":3000")
+	os.Setenv(s3gw.S3APIListenAddressEnvKey, ":3001") // empty disables S3 compatible API
+	os.Setenv(s3gw.HTTPReadHeaderTimeoutEnvKey, "10s")
+	os.Setenv(s3gw.HTTPReadTimeoutEnvKey, "15m")
+	os.Setenv(s3gw.HTTPWriteTimeoutEnvKey, "15m")
+	os.Setenv(s3gw.HTTPIdleTimeoutEnvKey, "2m")
+	os.Setenv(s3gw.HTTPShutdownTimeoutEnvKey, "30s")
+
+	os.Setenv(s3gw.TLSCertFileEnvKey, "") // empty serves plain HTTP on both listeners
+	os.Setenv(s3gw.TLSKeyFileEnvKey, "")
+	os.Setenv(s3gw.TLSClientCAFileEnvKey, "") // empty disables client certificate authentication
+	os.Setenv(s3gw.TLSReloadIntervalEnvKey, "1m")
+
+	os.Setenv(s3gw.AuthConfigFileEnvKey, "") // empty disables authentication
+	os.Setenv(s3gw.AuthMaxClockSkewEnvKey, "5m")
 }
 
 func main() {
-	backends, err := s3gw.Configure(context.Background())
+	os.Exit(run())
+}
+
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := s3gw.ConfigureKeyPolicy()
+	if err != nil {
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	auth, err := s3gw.LoadAuthenticator()
+	if err != nil {
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	certs, err := s3gw.LoadListenerTLS()
+	if err != nil {
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	namespaces, err := s3gw.LoadNamespaceConfigs()
+	if err != nil {
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	diskCache, err := s3gw.OpenDiskCache()
+	if err != nil {
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	keys, err := s3gw.OpenKeyManager(namespaces)
 	if err != nil {
-		log.Fatal(s3gw.CapitalizeErrorString(err))
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	backends, err := s3gw.Configure(ctx)
+	if err != nil {
+		log.Print(s3gw.CapitalizeErrorString(err))
+
+		return exitCodeFailure
+	}
+
+	defer backends.Close()
+
+	bg := s3gw.NewBackground()
+	defer bg.Stop()
+
+	store := s3gw.NewStore(backends, os.Getenv(s3gw.S3DefaultBucketNameEnvKey), namespaces, diskCache, keys)
+
+	err = store.LoadBucketCache(ctx)
+	if err != nil { // buckets are still checked on demand
+		log.Print(s3gw.CapitalizeErrorString(err))
+	}
+
+	bg.Go("multipart-gc", func(ctx context.Context) {
+		s3gw.RunMultipartGC(ctx, store)
+	})
+
+	bg.Go("erasure-repair", func(ctx context.Context) {
+		s3gw.RunErasureRepair(ctx, store)
+	})
+
+	bg.Go("dedup-gc", func(ctx context.Context) {
+		s3gw.RunDedupGC(ctx, store)
+	})
+
+	bg.Go("expiry-sweeper", func(ctx context.Context) {
+		s3gw.RunExpirySweeper(ctx, store)
+	})
+
+	scrubber := s3gw.NewScrubber(store)
+
+	bg.Go("scrubber", scrubber.Run)
+
+	rewrapper := s3gw.NewKeyRewrapper(store)
+
+	bg.Go("key-rewrap", rewrapper.Run)
+
+	if certs != nil {
+		bg.Go("tls-reload", certs.Run)
 	}
 
-	r := mux.NewRouter()
+	servers := []*http.Server{
+		s3gw.NewHTTPServer(os.Getenv(s3gw.HTTPListenAddressEnvKey), newRouter(store, scrubber, rewrapper, auth)),
+	}
+
+	if addr := os.Getenv(s3gw.S3APIListenAddressEnvKey); addr != "" {
+		servers = append(servers, s3gw.NewHTTPServer(addr, s3gw.S3BucketPathHandler(newS3Router(store, auth))))
+	}
+
+	serveErr := make(chan error, len(servers))
+
+	for _, srv := range servers {
+		srv.TLSConfig = certs.TLSConfig()
+
+		go func(srv *http.Server) {
+			if srv.TLSConfig != nil { // certificate is served by config
+				serveErr <- srv.ListenAndServeTLS("", "")
+
+				return
+			}
+
+			serveErr <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	select {
+	case err = <-serveErr:
+		log.Printf("Failed to start S3 Gateway service: %v", err)
+
+		return exitCodeFailure
+	case <-ctx.Done():
+		stop() // a second signal terminates immediately
+	}
+
+	log.Printf("Shutting down S3 Gateway service, draining in-flight requests...")
+
+	bg.Stop() // stop background workers before draining requests, they are not serving clients
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		s3gw.MustGetDurationFromEnv(s3gw.HTTPShutdownTimeoutEnvKey))
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		exitCode = exitCodeOK
+	)
+
+	for _, srv := range servers {
+		wg.Add(1)
+
+		go func(srv *http.Server) {
+			defer wg.Done()
+
+			err := srv.Shutdown(shutdownCtx)
+			if err != nil {
+				log.Printf("Failed to drain in-flight requests on %q: %v", srv.Addr, err)
+
+				_ = srv.Close()
+
+				mu.Lock()
+				exitCode = exitCodeFailure
+				mu.Unlock()
+			}
+		}(srv)
+	}
+
+	wg.Wait()
+
+	for range servers {
+		if err = <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("S3 Gateway service failed: %v", err)
+
+			exitCode = exitCodeFailure
+		}
+	}
+
+	log.Printf("S3 Gateway service stopped")
+
+	return exitCode
+}
+
+func newRouter(store *s3gw.Store, scrubber *s3gw.Scrubber, rewrapper *s3gw.KeyRewrapper, auth *s3gw.Authenticator) *mux.Router {
+	r := mux.NewRouter().UseEncodedPath() // IDs are decoded once in s3gw.GetID
 
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s3gw.HandleNotFound(w, r)
 	})
 
-	defaultBuckerName := os.Getenv(s3gw.S3DefaultBucketNameEnvKey)
+	r.Use(auth.Middleware)
+
+	r.HandleFunc("/ns", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleNamespaceList(w, r, store)
+	}).Methods(http.MethodGet)
 
-	r.HandleFunc("/object/{id}", func(w http.ResponseWriter, r *http.Request) {
-		s3gw.HandleObjectPut(w, r, backends, defaultBuckerName)
+	r.HandleFunc("/ns/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleNamespaceCreate(w, r, store)
 	}).Methods(http.MethodPut)
 
-	r.HandleFunc("/object/{id}", func(w http.ResponseWriter, r *http.Request) {
-		s3gw.HandleObjectGet(w, r, backends, defaultBuckerName)
+	r.HandleFunc("/ns/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleNamespaceDelete(w, r, store)
+	}).Methods(http.MethodDelete)
+
+	r.Handle("/metrics", s3gw.MetricsHandler()).Methods(http.MethodGet)
+
+	r.HandleFunc("/admin/scrub", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleScrubReport(w, r, scrubber)
 	}).Methods(http.MethodGet)
 
-	r.HandleFunc("/object", func(w http.ResponseWriter, r *http.Request) {
-		s3gw.HandleObjectList(w, r, backends, defaultBuckerName)
+	r.HandleFunc("/admin/scrub", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleScrubStart(w, r, scrubber)
+	}).Methods(http.MethodPost)
+
+	r.HandleFunc("/admin/keys/rewrap", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleRewrapReport(w, r, rewrapper)
 	}).Methods(http.MethodGet)
 
-	err = http.ListenAndServe(":3000", r)
-	if err != nil {
-		log.Fatalf("Failed to start S3 Gateway service: %v", err)
+	r.HandleFunc("/admin/keys/rewrap", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleRewrapStart(w, r, rewrapper)
+	}).Methods(http.MethodPost)
+
+	for _, prefix := range []string{"", "/ns/{namespace}"} { // default namespace and named namespaces
+		r.HandleFunc(prefix+"/object/{id:.+}/versions", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectVersions(w, r, store)
+		}).Methods(http.MethodGet)
+
+		r.HandleFunc(prefix+"/object/{id:.+}/tags", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectTagsGet(w, r, store)
+		}).Methods(http.MethodGet)
+
+		r.HandleFunc(prefix+"/object/{id:.+}/tags", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectTagsPut(w, r, store)
+		}).Methods(http.MethodPut)
+
+		r.HandleFunc(prefix+"/object/{id:.+}/tags", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectTagsDelete(w, r, store)
+		}).Methods(http.MethodDelete)
+
+		r.HandleFunc(prefix+"/object/{id:.+}/copy", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectCopy(w, r, store, false)
+		}).Methods(http.MethodPost).Queries("to", "{to}")
+
+		r.HandleFunc(prefix+"/object/{id:.+}/move", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectCopy(w, r, store, true)
+		}).Methods(http.MethodPost).Queries("to", "{to}")
+
+		r.HandleFunc(prefix+"/object/{id:.+}/restore", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectRestore(w, r, store)
+		}).Methods(http.MethodPost).Queries("versionId", "{versionId}")
+
+		r.HandleFunc(prefix+"/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleMultipartInitiate(w, r, store)
+		}).Methods(http.MethodPost).Queries("uploads", "")
+
+		r.HandleFunc(prefix+"/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleMultipartComplete(w, r, store)
+		}).Methods(http.MethodPost).Queries("uploadId", "{uploadId}")
+
+		r.HandleFunc(prefix+"/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleMultipartUploadPart(w, r, store)
+		}).Methods(http.MethodPut).Queries("uploadId", "{uploadId}", "partNumber", "{partNumber}")
+
+		r.HandleFunc(prefix+"/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleMultipartAbort(w, r, store)
+		}).Methods(http.MethodDelete).Queries("uploadId", "{uploadId}")
+
+		r.HandleFunc(prefix+"/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectPut(w, r, store)
+		}).Methods(http.MethodPut)
+
+		r.HandleFunc(prefix+"/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectGet(w, r, store)
+		}).Methods(http.MethodGet)
+
+		r.HandleFunc(prefix+"/object", func(w http.ResponseWriter, r *http.Request) {
+			s3gw.HandleObjectList(w, r, store)
+		}).Methods(http.MethodGet)
 	}
+
+	return r
+}
+
+func newS3Router(store *s3gw.Store, auth *s3gw.Authenticator) *mux.Router { // path-style S3 API, buckets are namespaces
+	r := mux.NewRouter().UseEncodedPath()
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3NotFound(w, r)
+	})
+
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3MethodNotAllowed(w, r)
+	})
+
+	r.Use(s3gw.S3RequestIDMiddleware, auth.S3Middleware)
+
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3ListBuckets(w, r, store)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3GetBucketLocation(w, r, store)
+	}).Methods(http.MethodGet).Queries("location", "")
+
+	r.HandleFunc("/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3ListObjects(w, r, store)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3HeadBucket(w, r, store)
+	}).Methods(http.MethodHead)
+
+	r.HandleFunc("/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3CreateBucket(w, r, store)
+	}).Methods(http.MethodPut)
+
+	r.HandleFunc("/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3DeleteBucket(w, r, store)
+	}).Methods(http.MethodDelete)
+
+	r.HandleFunc("/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3DeleteObjects(w, r, store)
+	}).Methods(http.MethodPost).Queries("delete", "")
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3CreateMultipartUpload(w, r, store)
+	}).Methods(http.MethodPost).Queries("uploads", "")
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3CompleteMultipartUpload(w, r, store)
+	}).Methods(http.MethodPost).Queries("uploadId", "{uploadId}")
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3UploadPart(w, r, store)
+	}).Methods(http.MethodPut).Queries("uploadId", "{uploadId}", "partNumber", "{partNumber}")
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3AbortMultipartUpload(w, r, store)
+	}).Methods(http.MethodDelete).Queries("uploadId", "{uploadId}")
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3PutObject(w, r, store)
+	}).Methods(http.MethodPut)
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3GetObject(w, r, store)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3HeadObject(w, r, store)
+	}).Methods(http.MethodHead)
+
+	r.HandleFunc("/{namespace}/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		s3gw.HandleS3DeleteObject(w, r, store)
+	}).Methods(http.MethodDelete)
+
+	return r
 }
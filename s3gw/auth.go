@@ -0,0 +1,398 @@
+package s3gw
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	AuthConfigFileEnvKey   = "AUTH_CONFIG_FILE"
+	AuthMaxClockSkewEnvKey = "AUTH_MAX_CLOCK_SKEW"
+)
+
+const (
+	APIKeyHeader            = "X-Api-Key"
+	AuthKeyIDHeader         = "X-Auth-Key-Id"
+	AuthTimestampHeader     = "X-Auth-Timestamp"
+	AuthSignatureHeader     = "X-Auth-Signature"
+	AuthContentSHA256Header = "X-Auth-Content-Sha256" // hex SHA-256 of body, empty for requests without body
+)
+
+var hmacSignedHeaders = []string{ // headers changing what a request writes, in signing order
+	AuthContentSHA256Header,
+	"Content-Type",
+	headerContentMD5,
+	headerChecksumSHA256,
+	headerChecksumCRC32C,
+	"If-Match",
+	"If-None-Match",
+	headerObjectExpires,
+	headerSSECAlgorithm,
+	headerSSECKey,
+	headerSSECKeyMD5,
+}
+
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionWrite  Action = "write"
+	ActionDelete Action = "delete"
+	ActionList   Action = "list"
+	ActionAdmin  Action = "admin" // scrubber, metrics and other operational endpoints
+)
+
+type AuthPolicy struct {
+	Actions    []Action `json:"actions"`
+	Namespaces []string `json:"namespaces"` // "*" matches any namespace
+	Prefixes   []string `json:"prefixes"`   // empty matches any key
+}
+
+type AuthKey struct {
+	ID       string       `json:"id"`
+	Secret   string       `json:"secret"`
+	Policies []AuthPolicy `json:"policies"`
+}
+
+type AuthConfig struct {
+	Keys []AuthKey `json:"keys"`
+}
+
+type Authenticator struct {
+	byID     map[string]*AuthKey
+	bySecret map[[sha256.Size]byte]*AuthKey
+
+	maxClockSkew time.Duration
+}
+
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errRequestExpired     = errors.New("request timestamp is outside of allowed clock skew")
+)
+
+func LoadAuthenticator() (*Authenticator, error) { // nil authenticator means that authentication is disabled
+	path := os.Getenv(AuthConfigFileEnvKey)
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config: %w", err)
+	}
+
+	var cfg AuthConfig
+
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse auth config %q: %w", path, err)
+	}
+
+	a := &Authenticator{
+		byID:         make(map[string]*AuthKey, len(cfg.Keys)),
+		bySecret:     make(map[[sha256.Size]byte]*AuthKey, len(cfg.Keys)),
+		maxClockSkew: MustGetDurationFromEnv(AuthMaxClockSkewEnvKey),
+	}
+
+	for i := range cfg.Keys {
+		key := &cfg.Keys[i]
+
+		if key.ID == "" || key.Secret == "" {
+			return nil, fmt.Errorf("auth key #%d must have both id and secret", i)
+		}
+
+		secretHash := sha256.Sum256([]byte(key.Secret))
+
+		if _, ok := a.byID[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate auth key id %q", key.ID)
+		}
+
+		if _, ok := a.bySecret[secretHash]; ok {
+			return nil, fmt.Errorf("duplicate secret for auth key %q", key.ID)
+		}
+
+		for _, p := range key.Policies {
+			for _, action := range p.Actions {
+				switch action {
+				case ActionRead, ActionWrite, ActionDelete, ActionList, ActionAdmin:
+				default:
+					return nil, fmt.Errorf("unknown action %q in policy of auth key %q", action, key.ID)
+				}
+			}
+		}
+
+		a.byID[key.ID] = key
+		a.bySecret[secretHash] = key
+	}
+
+	log.Printf("Loaded %d auth keys from %q", len(cfg.Keys), path)
+
+	return a, nil
+}
+
+type authKeyContextKey struct{}
+
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return a.middleware(next, func(w http.ResponseWriter, r *http.Request, status int) {
+		http.Error(w,
+			fmt.Sprintf("%d - %s", status, http.StatusText(status)),
+			status,
+		)
+	})
+}
+
+func (a *Authenticator) middleware(next http.Handler, deny func(w http.ResponseWriter, r *http.Request, status int)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a == nil {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		action, namespace, key := RequestResource(r)
+
+		authKey, err := a.Authenticate(r)
+		if err != nil {
+			log.Printf("Audit: unauthenticated %s %q from %s denied: %v",
+				action, r.URL.Path, r.RemoteAddr, err)
+
+			deny(w, r, http.StatusUnauthorized)
+
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), authKeyContextKey{}, authKey))
+
+		if action == "" { // authorization is done per key by handler
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if !authKey.Allows(action, namespace, key) {
+			log.Printf("Audit: key %q denied %s on %q in namespace %q from %s",
+				authKey.ID, action, key, namespace, r.RemoteAddr)
+
+			deny(w, r, http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func Authorized(r *http.Request, action Action, namespace, key string) bool { // for handlers touching more than routed resource
+	authKey, ok := r.Context().Value(authKeyContextKey{}).(*AuthKey)
+	if !ok {
+		return true // authentication is disabled
+	}
+
+	if !authKey.Allows(action, namespace, key) {
+		log.Printf("Audit: key %q denied %s on %q in namespace %q from %s",
+			authKey.ID, action, key, namespace, r.RemoteAddr)
+
+		return false
+	}
+
+	return true
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (*AuthKey, error) {
+	if token := r.Header.Get(APIKeyHeader); token != "" {
+		key, ok := a.bySecret[sha256.Sum256([]byte(token))]
+		if !ok {
+			return nil, errInvalidCredentials
+		}
+
+		return key, nil
+	}
+
+	if IsSigV4Request(r) {
+		return a.authenticateSigV4(r)
+	}
+
+	if keyID := r.Header.Get(AuthKeyIDHeader); keyID != "" {
+		return a.authenticateHMAC(r, keyID)
+	}
+
+	return nil, errMissingCredentials
+}
+
+func (a *Authenticator) authenticateHMAC(r *http.Request, keyID string) (*AuthKey, error) {
+	key, ok := a.byID[keyID]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+
+	timestamp := r.Header.Get(AuthTimestampHeader)
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	if !a.withinClockSkew(time.Unix(sec, 0)) {
+		return nil, errRequestExpired
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(AuthSignatureHeader))
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	if !hmac.Equal(signature, SignRequestHMAC(key.Secret, r, timestamp)) {
+		return nil, errInvalidCredentials
+	}
+
+	payloadHash := r.Header.Get(AuthContentSHA256Header)
+	if payloadHash == "" {
+		payloadHash = sigV4EmptySHA256
+	}
+
+	expected, err := hex.DecodeString(payloadHash)
+	if err != nil || len(expected) != sha256.Size {
+		return nil, errInvalidCredentials
+	}
+
+	r.Body = &sigV4HashingReader{ // signed length and hash keep captured request from being replayed with other body
+		body:     r.Body,
+		hash:     sha256.New(),
+		expected: expected,
+		size:     r.ContentLength,
+	}
+
+	return key, nil
+}
+
+func (a *Authenticator) withinClockSkew(t time.Time) bool {
+	skew := time.Since(t)
+	if skew < 0 {
+		skew = -skew
+	}
+
+	return skew <= a.maxClockSkew
+}
+
+func SignRequestHMAC(secret string, r *http.Request, timestamp string) []byte { // clients set body hash header before signing
+	parts := []string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, timestamp, strconv.FormatInt(r.ContentLength, 10)}
+
+	for _, name := range hmacSignedHeaders {
+		parts = append(parts, strings.Join(r.Header.Values(name), ","))
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(parts, "\n")))
+
+	return mac.Sum(nil)
+}
+
+func (k *AuthKey) Allows(action Action, namespace, key string) bool {
+	for _, p := range k.Policies {
+		if p.allows(action, namespace, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p AuthPolicy) allows(action Action, namespace, key string) bool {
+	if !slices.Contains(p.Actions, action) {
+		return false
+	}
+
+	if !slices.Contains(p.Namespaces, "*") && !slices.Contains(p.Namespaces, namespace) {
+		return false
+	}
+
+	if len(p.Prefixes) == 0 {
+		return true
+	}
+
+	if key == "" { // namespace wide operations require policy without key restrictions
+		return false
+	}
+
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func RequestResource(r *http.Request) (action Action, namespace, key string) {
+	vars := mux.Vars(r)
+
+	id, hasID := vars["id"]
+	if hasID {
+		key, _ = url.PathUnescape(id)
+	}
+
+	namespace = GetNamespace(r)
+
+	if route := mux.CurrentRoute(r); route != nil {
+		tpl, err := route.GetPathTemplate()
+
+		switch {
+		case err != nil:
+		case tpl == "/ns" || tpl == "/":
+			namespace = "*" // listing namespaces (buckets) is cluster wide
+		case tpl == "/metrics" || strings.HasPrefix(tpl, "/admin/"):
+			return ActionAdmin, "*", ""
+		case strings.HasSuffix(tpl, "/tags") && r.Method != http.MethodGet: // removing tags keeps object
+			return ActionWrite, namespace, key
+		case strings.HasSuffix(tpl, "/copy"): // destination is authorized by handler
+			return ActionRead, namespace, key
+		case strings.HasSuffix(tpl, "/move"):
+			return ActionDelete, namespace, key
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if hasID {
+			return ActionRead, namespace, key
+		}
+
+		return ActionList, namespace, r.URL.Query().Get("prefix")
+	case http.MethodPut:
+		if hasID && r.ContentLength == 0 && !r.URL.Query().Has("uploadId") { // zero-length PUT deletes object
+			return ActionDelete, namespace, key
+		}
+
+		return ActionWrite, namespace, key
+	case http.MethodDelete:
+		if r.URL.Query().Has("uploadId") { // aborting upload never touches stored object
+			return ActionWrite, namespace, key
+		}
+
+		return ActionDelete, namespace, key
+	case http.MethodPost:
+		if r.URL.Query().Has("delete") { // S3 DeleteObjects carries keys in body
+			return "", namespace, ""
+		}
+
+		return ActionWrite, namespace, key
+	default:
+		return ActionWrite, namespace, key
+	}
+}
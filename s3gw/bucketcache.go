@@ -0,0 +1,147 @@
+package s3gw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+type bucketCache struct { // buckets known to exist per backend, missing buckets are always rechecked
+	mu      sync.RWMutex
+	buckets map[string]map[string]struct{}
+}
+
+func newBucketCache() *bucketCache {
+	return &bucketCache{
+		buckets: make(map[string]map[string]struct{}),
+	}
+}
+
+func (c *bucketCache) known(backendID, bucketName string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.buckets[backendID][bucketName]
+
+	return ok
+}
+
+func (c *bucketCache) add(backendID, bucketName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.buckets[backendID] == nil {
+		c.buckets[backendID] = make(map[string]struct{})
+	}
+
+	c.buckets[backendID][bucketName] = struct{}{}
+}
+
+func (c *bucketCache) forget(backendID, bucketName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.buckets[backendID], bucketName)
+}
+
+func (c *bucketCache) replace(backendID string, bucketNames []string) {
+	buckets := make(map[string]struct{}, len(bucketNames))
+	for _, name := range bucketNames {
+		buckets[name] = struct{}{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buckets[backendID] = buckets
+}
+
+func (s *Store) LoadBucketCache(ctx context.Context) error { // called at startup and whenever members are added
+	var errs []error
+
+	for _, bDef := range s.backends.GetMembers() {
+		err := s.refreshBucketCache(ctx, bDef)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) refreshBucketCache(ctx context.Context, bDef BackendDef) error {
+	buckets, err := bDef.MinioClient.ListBuckets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list S3 buckets on %q: %w", bDef.Name, err)
+	}
+
+	var errs []error
+
+	names := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		err = s.enableVersioning(ctx, bDef, bucket.Name) // namespace may have been configured after its buckets were created
+		if err != nil {
+			errs = append(errs, err)
+
+			continue // rechecked when namespace is next written
+		}
+
+		names = append(names, bucket.Name)
+	}
+
+	s.buckets.replace(bDef.Name, names)
+
+	log.Printf("Cached %d buckets of %q", len(names), bDef.Name)
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) bucketExists(ctx context.Context, bDef BackendDef, bucketName string) (bool, error) {
+	if s.buckets.known(bDef.Name, bucketName) {
+		return true, nil
+	}
+
+	exists, err := bDef.MinioClient.BucketExists(ctx, bucketName)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		s.buckets.add(bDef.Name, bucketName)
+	}
+
+	return exists, nil
+}
+
+func (s *Store) ensureBucket(ctx context.Context, bDef BackendDef, bucketName string) error {
+	if s.buckets.known(bDef.Name, bucketName) {
+		return nil
+	}
+
+	err := EnsureBucketExists(ctx, bDef.MinioClient, bucketName)
+	if err == nil {
+		err = s.enableVersioning(ctx, bDef, bucketName)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.buckets.add(bDef.Name, bucketName)
+
+	return nil
+}
+
+func (s *Store) checkBucketError(backendID, bucketName string, err error) error { // bucket was removed behind our back
+	var resp minio.ErrorResponse
+
+	if errors.As(err, &resp) && resp.Code == "NoSuchBucket" {
+		s.buckets.forget(backendID, bucketName)
+	}
+
+	return err
+}
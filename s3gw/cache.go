@@ -0,0 +1,243 @@
+package s3gw
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ObjectCacheSizeEnvKey          = "OBJECT_CACHE_SIZE" // bytes, 0 disables cache
+	ObjectCacheMaxObjectSizeEnvKey = "OBJECT_CACHE_MAX_OBJECT_SIZE"
+	ObjectCacheTTLEnvKey           = "OBJECT_CACHE_TTL" // bounds staleness of writes done by other gateways
+)
+
+var (
+	objectCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "object_cache",
+		Name:      "hits_total",
+		Help:      "Object reads served from memory.",
+	})
+	objectCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "object_cache",
+		Name:      "misses_total",
+		Help:      "Object reads not found in memory.",
+	})
+	objectCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "object_cache",
+		Name:      "evictions_total",
+		Help:      "Objects dropped from memory to stay within size limit.",
+	})
+	objectCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "object_cache",
+		Name:      "bytes",
+		Help:      "Size of objects held in memory.",
+	})
+)
+
+type objectCacheEntry struct {
+	key     string
+	info    ObjectInfo
+	data    []byte
+	expires time.Time
+}
+
+type objectCacheFill struct { // read in flight, dropped when object changes meanwhile
+	stale bool
+}
+
+type objectCache struct {
+	maxSize       int64
+	maxObjectSize int64
+	ttl           time.Duration
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // front is most recently used
+	items map[string]*list.Element
+	fills map[string][]*objectCacheFill
+}
+
+func newObjectCache() *objectCache { // nil cache is valid and always misses
+	maxSize := int64(MustGetIntFromEnv(ObjectCacheSizeEnvKey))
+	if maxSize <= 0 {
+		return nil
+	}
+
+	maxObjectSize := int64(MustGetIntFromEnv(ObjectCacheMaxObjectSizeEnvKey))
+	if maxObjectSize <= 0 || maxObjectSize > maxSize {
+		maxObjectSize = maxSize
+	}
+
+	return &objectCache{
+		maxSize:       maxSize,
+		maxObjectSize: maxObjectSize,
+		ttl:           MustGetDurationFromEnv(ObjectCacheTTLEnvKey),
+		lru:           list.New(),
+		items:         make(map[string]*list.Element),
+		fills:         make(map[string][]*objectCacheFill),
+	}
+}
+
+func objectCacheKey(namespace, id string) string {
+	return namespace + "\x00" + id
+}
+
+func (c *objectCache) cacheable(size int64) bool {
+	return c != nil && size >= 0 && size <= c.maxObjectSize
+}
+
+func (c *objectCache) get(namespace, id string) (*objectCacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	key := objectCacheKey(namespace, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		objectCacheMisses.Inc()
+
+		return nil, false
+	}
+
+	entry := el.Value.(*objectCacheEntry)
+
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.remove(el)
+		objectCacheBytes.Set(float64(c.size))
+		objectCacheMisses.Inc()
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	objectCacheHits.Inc()
+
+	return entry, true
+}
+
+func (c *objectCache) startFill(namespace, id string) *objectCacheFill { // must be called before backend is read
+	if c == nil {
+		return nil
+	}
+
+	key := objectCacheKey(namespace, id)
+	fill := &objectCacheFill{}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fills[key] = append(c.fills[key], fill)
+
+	return fill
+}
+
+func (c *objectCache) finishFill(fill *objectCacheFill, namespace, id string, info ObjectInfo, data []byte) {
+	if c == nil || fill == nil {
+		return
+	}
+
+	key := objectCacheKey(namespace, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fills := c.fills[key]
+	for i, f := range fills {
+		if f == fill {
+			fills = append(fills[:i], fills[i+1:]...)
+
+			break
+		}
+	}
+
+	if len(fills) == 0 {
+		delete(c.fills, key)
+	} else {
+		c.fills[key] = fills
+	}
+
+	if fill.stale || data == nil || int64(len(data)) > c.maxObjectSize {
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	c.items[key] = c.lru.PushFront(&objectCacheEntry{
+		key:     key,
+		info:    info,
+		data:    data,
+		expires: time.Now().Add(c.ttl),
+	})
+	c.size += int64(len(data))
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+		objectCacheEvictions.Inc()
+	}
+
+	objectCacheBytes.Set(float64(c.size))
+}
+
+func (c *objectCache) invalidate(namespace, id string) {
+	if c == nil {
+		return
+	}
+
+	key := objectCacheKey(namespace, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, fill := range c.fills[key] {
+		fill.stale = true
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+		objectCacheBytes.Set(float64(c.size))
+	}
+}
+
+func (c *objectCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*objectCacheEntry)
+
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+func (e *objectCacheEntry) reader(br *ByteRange) (*ObjectReader, error) {
+	offset, length, err := br.Resolve(e.info.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectReader{
+		ReadCloser: io.NopCloser(bytes.NewReader(e.data[offset : offset+length])),
+		Info:       e.info,
+		Offset:     offset,
+		Length:     length,
+	}, nil
+}
+
+func (e *objectCacheEntry) verify(expected Checksums) bool {
+	hashes := newChecksumHashes(expected)
+	hashes.Write(e.data)
+
+	return hashes.verify()
+}
@@ -0,0 +1,273 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	ChecksumVerifyOnReadEnvKey = "CHECKSUM_VERIFY_ON_READ"
+)
+
+const (
+	headerContentMD5           = "Content-Md5"
+	headerChecksumSHA256       = "X-Amz-Checksum-Sha256"
+	headerChecksumCRC32C       = "X-Amz-Checksum-Crc32c"
+	headerChecksumVerification = "X-Checksum-Verification" // response trailer of verified reads
+)
+
+const (
+	metaChecksumSHA256 = "Gw-Checksum-Sha256"
+	metaChecksumCRC32C = "Gw-Checksum-Crc32c"
+)
+
+var (
+	ErrInvalidDigest   = errors.New("invalid checksum header")
+	ErrBadDigest       = errors.New("checksum of received data does not match checksum header")
+	ErrCorruptedObject = errors.New("object data served by backend does not match stored checksum")
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type Checksums struct { // base64 encoded digests, empty when unknown
+	MD5    string
+	SHA256 string
+	CRC32C string
+}
+
+func ChecksumsFromRequest(h http.Header) (Checksums, error) {
+	cs := Checksums{
+		MD5:    h.Get(headerContentMD5),
+		SHA256: h.Get(headerChecksumSHA256),
+		CRC32C: h.Get(headerChecksumCRC32C),
+	}
+
+	for _, c := range []struct {
+		value string
+		size  int
+	}{
+		{cs.MD5, md5.Size},
+		{cs.SHA256, sha256.Size},
+		{cs.CRC32C, crc32.Size},
+	} {
+		if c.value == "" {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(c.value)
+		if err != nil || len(sum) != c.size {
+			return Checksums{}, ErrInvalidDigest
+		}
+	}
+
+	return cs, nil
+}
+
+func checksumsFromMetadata(m map[string]string) Checksums {
+	return Checksums{
+		SHA256: metaValue(m, metaChecksumSHA256),
+		CRC32C: metaValue(m, metaChecksumCRC32C),
+	}
+}
+
+func (cs Checksums) metadata(userMetadata map[string]string) map[string]string { // MD5 is kept by backend as ETag
+	out := make(map[string]string, len(userMetadata)+2)
+	for k, v := range userMetadata {
+		out[k] = v
+	}
+
+	if cs.SHA256 != "" {
+		out[metaChecksumSHA256] = cs.SHA256
+	}
+
+	if cs.CRC32C != "" {
+		out[metaChecksumCRC32C] = cs.CRC32C
+	}
+
+	return out
+}
+
+func (cs Checksums) md5Hex() string { // as used in ETag
+	sum, err := base64.StdEncoding.DecodeString(cs.MD5)
+	if err != nil || len(sum) != md5.Size {
+		return ""
+	}
+
+	return hex.EncodeToString(sum)
+}
+
+func writeChecksumHeaders(w http.ResponseWriter, cs Checksums) {
+	if cs.SHA256 != "" {
+		w.Header().Set(headerChecksumSHA256, cs.SHA256)
+	}
+
+	if cs.CRC32C != "" {
+		w.Header().Set(headerChecksumCRC32C, cs.CRC32C)
+	}
+}
+
+type checksumHashes struct {
+	expected Checksums
+
+	md5, sha256, crc32c hash.Hash
+}
+
+func newChecksumHashes(expected Checksums) *checksumHashes {
+	c := &checksumHashes{
+		expected: expected,
+	}
+
+	if expected.MD5 != "" {
+		c.md5 = md5.New()
+	}
+
+	if expected.SHA256 != "" {
+		c.sha256 = sha256.New()
+	}
+
+	if expected.CRC32C != "" {
+		c.crc32c = crc32.New(crc32cTable)
+	}
+
+	return c
+}
+
+func (c *checksumHashes) Write(p []byte) (int, error) {
+	for _, h := range []hash.Hash{c.md5, c.sha256, c.crc32c} {
+		if h != nil {
+			h.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *checksumHashes) verify() bool {
+	for _, v := range []struct {
+		h        hash.Hash
+		expected string
+	}{
+		{c.md5, c.expected.MD5},
+		{c.sha256, c.expected.SHA256},
+		{c.crc32c, c.expected.CRC32C},
+	} {
+		if v.h != nil && base64.StdEncoding.EncodeToString(v.h.Sum(nil)) != v.expected {
+			return false
+		}
+	}
+
+	return true
+}
+
+type ChecksumReader struct { // fails upload when body does not match checksum headers
+	r      io.Reader
+	hashes *checksumHashes
+	size   int64 // declared length, -1 when unknown
+	read   int64
+	err    error
+}
+
+func NewChecksumReader(r io.Reader, expected Checksums, size int64) *ChecksumReader {
+	return &ChecksumReader{
+		r:      r,
+		hashes: newChecksumHashes(expected),
+		size:   size,
+	}
+}
+
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.r.Read(p)
+	c.hashes.Write(p[:n])
+	c.read += int64(n)
+
+	if (err == io.EOF || c.read == c.size) && !c.hashes.verify() {
+		c.err = ErrBadDigest
+
+		return 0, c.err // last bytes are withheld, backend must never see complete body
+	}
+
+	return n, err
+}
+
+func (c *ChecksumReader) Err() error { // backend errors wrap read errors inconsistently
+	return c.err
+}
+
+func VerifyOnRead() bool {
+	v, _ := strconv.ParseBool(os.Getenv(ChecksumVerifyOnReadEnvKey))
+
+	return v
+}
+
+func expectedReadChecksums(info ObjectInfo) (Checksums, bool) {
+	if info.Checksums.SHA256 != "" || info.Checksums.CRC32C != "" {
+		return Checksums{SHA256: info.Checksums.SHA256, CRC32C: info.Checksums.CRC32C}, true
+	}
+
+	if (info.compression != "" || info.envelope != nil) && info.ETag == info.headETag { // MD5 of stored bytes
+		return Checksums{}, false
+	}
+
+	sum, err := hex.DecodeString(info.ETag) // ETag of single part upload is MD5 of content
+	if err != nil || len(sum) != md5.Size {
+		return Checksums{}, false
+	}
+
+	return Checksums{MD5: base64.StdEncoding.EncodeToString(sum)}, true
+}
+
+func verifiableRead(object *ObjectReader) (Checksums, bool) { // only whole objects can be verified
+	if !VerifyOnRead() || object.ReadCloser == nil || !object.whole() {
+		return Checksums{}, false
+	}
+
+	return expectedReadChecksums(object.Info)
+}
+
+func announceChecksumTrailer(w http.ResponseWriter, object *ObjectReader) { // trailers must be declared before headers are written
+	if _, ok := verifiableRead(object); !ok {
+		return
+	}
+
+	w.Header().Set("Trailer", headerChecksumVerification)
+	w.Header().Del("Content-Length") // trailers need chunked response
+}
+
+func copyObjectVerified(w http.ResponseWriter, object *ObjectReader) (int64, error) {
+	expected, ok := verifiableRead(object)
+	if !ok {
+		return io.Copy(w, object)
+	}
+
+	hashes := newChecksumHashes(expected)
+
+	n, err := io.Copy(io.MultiWriter(w, hashes), object)
+	if err != nil {
+		return n, err
+	}
+
+	if !hashes.verify() {
+		w.Header().Set(headerChecksumVerification, "failed")
+		log.Printf("Object %q served by %q does not match stored checksum", object.Info.Key, object.Info.Backend)
+
+		return n, ErrCorruptedObject
+	}
+
+	w.Header().Set(headerChecksumVerification, "passed")
+
+	return n, nil
+}
@@ -0,0 +1,238 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	ChunkedStorageThresholdEnvKey = "CHUNKED_STORAGE_THRESHOLD" // bytes, objects above are striped, 0 disables
+	ChunkSizeEnvKey               = "CHUNK_SIZE"
+	ChunkPrefetchEnvKey           = "CHUNK_PREFETCH" // chunks fetched in parallel on GET
+)
+
+func chunkRingKey(namespace, id string, index int) string { // chunks are placed on ring by id+chunkIndex
+	return RingKey(namespace, id) + "#" + strconv.Itoa(index)
+}
+
+func chunkKey(writeID string, index int) string { // unique per write, overwrite must not clobber chunks still referenced
+	return fmt.Sprintf("chunks/%s/%06d", writeID, index)
+}
+
+func (s *Store) isChunked(size int64) bool {
+	return s.chunkThreshold > 0 && s.chunkSize > 0 && (size < 0 || size > s.chunkThreshold)
+}
+
+func (s *Store) putChunked(ctx context.Context, client *minio.Client, bucketName, namespace, id string, body io.Reader, size int64, opts PutOptions) (minio.UploadInfo, *Manifest, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return minio.UploadInfo{}, nil, err
+	}
+
+	writeID := hex.EncodeToString(b)
+
+	m := &Manifest{
+		ChunkSize: s.chunkSize,
+	}
+
+	var buf []byte
+	if size < 0 { // streamed body, chunk is buffered to learn its size
+		buf = make([]byte, s.chunkSize)
+	}
+
+	h := md5.New()
+
+	for index := 0; size < 0 || m.Size < size; index++ {
+		var (
+			chunk io.Reader
+			n     int64
+		)
+
+		if size < 0 {
+			nr, err := io.ReadFull(body, buf)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				s.releaseManifest(ctx, m)
+
+				return minio.UploadInfo{}, nil, err
+			}
+
+			if nr == 0 {
+				break
+			}
+
+			chunk, n = bytes.NewReader(buf[:nr]), int64(nr)
+		} else {
+			n = min(s.chunkSize, size-m.Size)
+			chunk = io.LimitReader(body, n)
+		}
+
+		data, stored, meta, err := s.sealPart(ctx, namespace, io.TeeReader(chunk, h), n)
+		if err != nil {
+			s.releaseManifest(ctx, m)
+
+			return minio.UploadInfo{}, nil, err
+		}
+
+		info, _, err := s.putInternal(ctx, chunkRingKey(namespace, id, index), chunkKey(writeID, index),
+			data, stored, meta)
+		if err != nil {
+			s.releaseManifest(ctx, m)
+
+			return minio.UploadInfo{}, nil, fmt.Errorf("failed to upload chunk %d of object %q: %w", index, id, err)
+		}
+
+		m.Parts = append(m.Parts, ManifestPart{
+			RingKey:   chunkRingKey(namespace, id, index),
+			Key:       chunkKey(writeID, index),
+			Size:      n,
+			ETag:      info.ETag,
+			Encrypted: meta != nil,
+		})
+		m.Size += n
+
+		if size < 0 && n < s.chunkSize {
+			break
+		}
+	}
+
+	m.ETag = hex.EncodeToString(h.Sum(nil))
+
+	info, err := s.putManifest(ctx, client, bucketName, id, m, opts)
+	if err != nil {
+		s.releaseManifest(ctx, m)
+
+		return minio.UploadInfo{}, nil, err
+	}
+
+	return info, m, nil
+}
+
+type chunkSpan struct {
+	part   ManifestPart
+	offset int64
+	length int64
+}
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+type prefetchReader struct { // reassembles striped object, fetching up to N chunks ahead in parallel
+	cancel  context.CancelFunc
+	results []chan chunkResult
+	sem     chan struct{}
+
+	next int
+	cur  *bytes.Reader
+	err  error
+}
+
+func (s *Store) newPrefetchReader(ctx context.Context, m *Manifest, offset, length int64, prefetch int) *prefetchReader {
+	spans := make([]chunkSpan, 0)
+
+	for _, part := range m.Parts { // only chunks overlapping range are fetched
+		if length <= 0 {
+			break
+		}
+
+		if offset >= part.Size {
+			offset -= part.Size
+
+			continue
+		}
+
+		n := min(part.Size-offset, length)
+		spans = append(spans, chunkSpan{part: part, offset: offset, length: n})
+
+		offset = 0
+		length -= n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	r := &prefetchReader{
+		cancel:  cancel,
+		results: make([]chan chunkResult, len(spans)),
+		sem:     make(chan struct{}, max(prefetch, 1)),
+	}
+
+	for i := range r.results {
+		r.results[i] = make(chan chunkResult, 1)
+	}
+
+	go func() {
+		for i, span := range spans {
+			select {
+			case r.sem <- struct{}{}: // released by consumer, bounds buffered chunks
+			case <-ctx.Done():
+				return
+			}
+
+			go func(res chan<- chunkResult, span chunkSpan) {
+				res <- s.fetchChunk(ctx, span)
+			}(r.results[i], span)
+		}
+	}()
+
+	return r
+}
+
+func (s *Store) fetchChunk(ctx context.Context, span chunkSpan) chunkResult {
+	rc, err := s.getPart(ctx, span.part, span.offset, span.length)
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	defer rc.Close()
+
+	data := make([]byte, span.length)
+
+	_, err = io.ReadFull(rc, data)
+	if err != nil {
+		return chunkResult{err: fmt.Errorf("failed to read chunk %q: %w", span.part.Key, err)}
+	}
+
+	return chunkResult{data: data}
+}
+
+func (r *prefetchReader) Read(p []byte) (int, error) {
+	for r.err == nil && (r.cur == nil || r.cur.Len() == 0) {
+		if r.cur != nil {
+			r.cur = nil
+			<-r.sem
+		}
+
+		if r.next == len(r.results) {
+			r.err = io.EOF
+
+			break
+		}
+
+		res := <-r.results[r.next]
+		r.next++
+
+		r.cur, r.err = bytes.NewReader(res.data), res.err
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return r.cur.Read(p)
+}
+
+func (r *prefetchReader) Close() error {
+	r.cancel()
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package s3gw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+const metaCompression = "Gw-Compression" // algorithm of stored bytes, Gw-Size holds original size
+
+var errUnknownCompression = errors.New("unknown compression algorithm")
+
+func compressing(body io.Reader, algorithm string) io.ReadCloser { // caller must close to stop compressor on failed upload
+	pr, pw := io.Pipe()
+
+	go func() {
+		w, err := newCompressor(pw, algorithm)
+		if err != nil {
+			pw.CloseWithError(err)
+
+			return
+		}
+
+		_, err = io.Copy(w, body)
+
+		pw.CloseWithError(errors.Join(err, w.Close()))
+	}()
+
+	return pr
+}
+
+func newCompressor(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownCompression, algorithm)
+	}
+}
+
+type decompressor struct {
+	io.Reader
+
+	close func()
+	body  io.Closer
+}
+
+func (d *decompressor) Close() error {
+	d.close()
+
+	return d.body.Close()
+}
+
+func decompressing(body io.ReadCloser, algorithm string, offset, length int64) (io.ReadCloser, error) { // range is applied to original bytes
+	d := &decompressor{body: body}
+
+	switch algorithm {
+	case CompressionZstd:
+		dec, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			body.Close()
+
+			return nil, err
+		}
+
+		d.Reader, d.close = dec, dec.Close
+	case CompressionGzip:
+		dec, err := gzip.NewReader(body)
+		if err != nil {
+			body.Close()
+
+			return nil, err
+		}
+
+		d.Reader, d.close = dec, func() { dec.Close() }
+	default:
+		body.Close()
+
+		return nil, fmt.Errorf("%w %q", errUnknownCompression, algorithm)
+	}
+
+	if offset > 0 { // compressed streams are not seekable
+		_, err := io.CopyN(io.Discard, d.Reader, offset)
+		if err != nil {
+			d.Close()
+
+			return nil, err
+		}
+	}
+
+	d.Reader = io.LimitReader(d.Reader, length)
+
+	return d, nil
+}
+
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+
+		v, err := strconv.ParseFloat(q, 64)
+
+		return err == nil && v > 0
+	}
+
+	return false
+}
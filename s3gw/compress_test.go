@@ -0,0 +1,153 @@
+package s3gw
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func compressedTestObject(t *testing.T, plain []byte, algorithm string) []byte {
+	t.Helper()
+
+	rc := compressing(bytes.NewReader(plain), algorithm)
+	defer rc.Close()
+
+	compressed, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("Failed to compress with %s: %v", algorithm, err)
+	}
+
+	return compressed
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	random := make([]byte, 64<<10)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate body: %v", err)
+	}
+
+	plain := append(bytes.Repeat([]byte("compressible "), 16<<10), random...)
+	size := int64(len(plain))
+
+	for _, algorithm := range []string{CompressionZstd, CompressionGzip} {
+		compressed := compressedTestObject(t, plain, algorithm)
+		if len(compressed) >= len(plain) {
+			t.Errorf("Expected %s to shrink body of %d bytes, got %d", algorithm, len(plain), len(compressed))
+		}
+
+		for _, br := range []*ByteRange{
+			nil,
+			{Start: 0, End: 0},
+			{Start: 100, End: 199},
+			{Start: size - 10, End: -1},
+			{Start: -1, End: 70 << 10}, // suffix crosses into random tail
+			{Start: 5, End: size + 100},
+		} {
+			info := ObjectInfo{Key: "obj", Size: size, compression: algorithm}
+
+			object, err := (&Store{}).decompressedObject(io.NopCloser(bytes.NewReader(compressed)), info, br)
+			if err != nil {
+				t.Fatalf("Failed to decompress %s range %v: %v", algorithm, br, err)
+			}
+
+			data, err := io.ReadAll(object)
+			object.Close()
+
+			if err != nil {
+				t.Fatalf("Failed to read %s range %v: %v", algorithm, br, err)
+			}
+
+			offset, length, _ := br.Resolve(size)
+			if object.Offset != offset || object.Length != length || !bytes.Equal(data, plain[offset:offset+length]) {
+				t.Errorf("Expected %s range %v to return %d bytes at %d, got %d bytes at %d",
+					algorithm, br, length, offset, len(data), object.Offset)
+			}
+		}
+
+		_, err := (&Store{}).decompressedObject(io.NopCloser(bytes.NewReader(compressed)),
+			ObjectInfo{Size: size, compression: algorithm}, &ByteRange{Start: size, End: -1})
+		if !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("Expected %v for %s range past end, got %v", ErrInvalidRange, algorithm, err)
+		}
+	}
+
+	_, err := decompressing(io.NopCloser(bytes.NewReader(plain)), "brotli", 0, size)
+	if err == nil {
+		t.Errorf("Expected unknown algorithm to be rejected")
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		header   string
+		encoding string
+		expected bool
+	}{
+		{"", CompressionGzip, false},
+		{"gzip", CompressionGzip, true},
+		{"deflate, GZIP", CompressionGzip, true},
+		{"gzip;q=0.5, zstd", CompressionZstd, true},
+		{"gzip;q=0", CompressionGzip, false},
+		{"zstd;q=0.0", CompressionZstd, false},
+		{"br, gzip", CompressionZstd, false},
+		{"gzipx", CompressionGzip, false},
+	} {
+		if acceptsEncoding(tc.header, tc.encoding) != tc.expected {
+			t.Errorf("Expected Accept-Encoding %q to accept %s: %v", tc.header, tc.encoding, tc.expected)
+		}
+	}
+}
+
+func TestCompressedObjectHeaders(t *testing.T) {
+	info := ObjectInfo{Key: "obj", Size: 1000, ETag: "etag", compression: CompressionZstd}
+
+	for _, tc := range []struct {
+		name     string
+		object   ObjectReader
+		status   int
+		encoding string
+		length   int64
+		rng      string
+	}{
+		{"passthrough", ObjectReader{Info: info, Length: 120, ContentEncoding: CompressionZstd}, http.StatusOK, CompressionZstd, 120, ""},
+		{"decompressed", ObjectReader{Info: info, Length: 1000}, http.StatusOK, "", 1000, ""},
+		{"decompressed range", ObjectReader{Info: info, Offset: 100, Length: 50}, http.StatusPartialContent, "", 50, "bytes 100-149/1000"},
+	} {
+		rec := httptest.NewRecorder()
+		writeObjectHeaders(rec, &tc.object)
+
+		h := rec.Result().Header
+
+		if rec.Code != tc.status {
+			t.Errorf("Expected status %d for %s, got %d", tc.status, tc.name, rec.Code)
+		}
+
+		if v := h.Get("Vary"); v != "Accept-Encoding" {
+			t.Errorf("Expected Vary: Accept-Encoding for %s, got %q", tc.name, v)
+		}
+
+		if v := h.Get("Content-Encoding"); v != tc.encoding {
+			t.Errorf("Expected Content-Encoding %q for %s, got %q", tc.encoding, tc.name, v)
+		}
+
+		if v := h.Get("Content-Length"); v != strconv.FormatInt(tc.length, 10) {
+			t.Errorf("Expected Content-Length %d for %s, got %q", tc.length, tc.name, v)
+		}
+
+		if v := h.Get("Content-Range"); v != tc.rng {
+			t.Errorf("Expected Content-Range %q for %s, got %q", tc.rng, tc.name, v)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	writeObjectHeaders(rec, &ObjectReader{Info: ObjectInfo{Key: "obj", Size: 10}, Length: 10})
+
+	if v := rec.Result().Header.Get("Vary"); v != "" {
+		t.Errorf("Expected no Vary for uncompressed object, got %q", v)
+	}
+}
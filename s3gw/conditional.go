@@ -0,0 +1,143 @@
+package s3gw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+type WriteConditions struct { // evaluated against current object while its key is locked
+	IfMatch     string // ETag list or "*", object must exist and match
+	IfNoneMatch string // ETag list or "*", "*" creates object only if absent
+}
+
+func WriteConditionsFromRequest(h http.Header) WriteConditions {
+	return WriteConditions{
+		IfMatch:     h.Get("If-Match"),
+		IfNoneMatch: h.Get("If-None-Match"),
+	}
+}
+
+func (c WriteConditions) empty() bool {
+	return c.IfMatch == "" && c.IfNoneMatch == ""
+}
+
+func etagListMatches(list, etag string) bool { // weak and strong ETags compare equal, gateway ETags are never weak
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.Trim(strings.TrimPrefix(candidate, "W/"), `"`) == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c WriteConditions) check(info ObjectInfo, exists bool) error {
+	if c.IfMatch != "" && (!exists || !etagListMatches(c.IfMatch, info.ETag)) {
+		return ErrPreconditionFailed
+	}
+
+	if c.IfNoneMatch != "" && exists && etagListMatches(c.IfNoneMatch, info.ETag) {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+func (s *Store) checkConditions(ctx context.Context, namespace, id string, c WriteConditions, sse encrypt.ServerSide) error { // caller holds key lock
+	if c.empty() {
+		return nil
+	}
+
+	info, err := s.StatObject(ctx, namespace, id, GetOptions{SSEC: sse})
+
+	exists := err == nil
+	if errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrBucketNotFound) {
+		err = nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to check preconditions of object %q: %w", id, err)
+	}
+
+	err = c.check(info, exists)
+	if err != nil {
+		return fmt.Errorf("object %q: %w", id, err)
+	}
+
+	return nil
+}
+
+type keyLocks struct { // serializes writes per object within this gateway
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int // holder and waiters, entry is dropped when last one leaves
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		locks: make(map[string]*keyLock),
+	}
+}
+
+func (l *keyLocks) lock(key string) (unlock func()) {
+	l.mu.Lock()
+
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+
+	kl.refs++
+
+	l.mu.Unlock()
+
+	kl.Lock()
+
+	return func() {
+		kl.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+func (s *Store) lockObject(namespace, id string) (unlock func()) {
+	return s.locks.lock(RingKey(namespace, id))
+}
+
+func (s *Store) lockObjects(namespace, a, b string) (unlock func()) { // fixed order, crossing copies must not deadlock
+	if b < a {
+		a, b = b, a
+	}
+
+	unlockA := s.lockObject(namespace, a)
+	unlockB := s.lockObject(namespace, b)
+
+	return func() {
+		unlockB()
+		unlockA()
+	}
+}
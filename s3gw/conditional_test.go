@@ -0,0 +1,72 @@
+package s3gw
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestWriteConditions(t *testing.T) {
+	current := ObjectInfo{ETag: "abc"}
+
+	for name, tc := range map[string]struct {
+		conditions WriteConditions
+		exists     bool
+		ok         bool
+	}{
+		"none":                   {WriteConditions{}, true, true},
+		"create only, absent":    {WriteConditions{IfNoneMatch: "*"}, false, true},
+		"create only, present":   {WriteConditions{IfNoneMatch: "*"}, true, false},
+		"swap, matching":         {WriteConditions{IfMatch: `"abc"`}, true, true},
+		"swap, weak matching":    {WriteConditions{IfMatch: `W/"abc"`}, true, true},
+		"swap, list":             {WriteConditions{IfMatch: `"xyz", "abc"`}, true, true},
+		"swap, stale":            {WriteConditions{IfMatch: `"xyz"`}, true, false},
+		"swap, absent":           {WriteConditions{IfMatch: `"abc"`}, false, false},
+		"any, present":           {WriteConditions{IfMatch: "*"}, true, true},
+		"any, absent":            {WriteConditions{IfMatch: "*"}, false, false},
+		"not etag, other":        {WriteConditions{IfNoneMatch: `"xyz"`}, true, true},
+		"not etag, same":         {WriteConditions{IfNoneMatch: `"abc"`}, true, false},
+		"both, matching and new": {WriteConditions{IfMatch: `"abc"`, IfNoneMatch: `"xyz"`}, true, true},
+	} {
+		err := tc.conditions.check(current, tc.exists)
+		if tc.ok && err != nil {
+			t.Errorf("Expected %s to pass, got %v", name, err)
+		}
+
+		if !tc.ok && !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("Expected %s to fail precondition, got %v", name, err)
+		}
+	}
+}
+
+func TestKeyLocks(t *testing.T) {
+	locks := newKeyLocks()
+
+	var wg sync.WaitGroup
+
+	counter := 0
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			unlock := locks.lock("ns/id")
+			defer unlock()
+
+			v := counter // read and write would race without lock
+			counter = v + 1
+		}()
+	}
+
+	wg.Wait()
+
+	if counter != 50 {
+		t.Errorf("Expected 50 serialized increments, got %d", counter)
+	}
+
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected released locks to be dropped, %d left", len(locks.locks))
+	}
+}
@@ -1,6 +1,7 @@
 package s3gw
 
 import (
+	"net/http"
 	"sync"
 
 	"github.com/buraksezer/consistent"
@@ -11,6 +12,9 @@ type Backends struct {
 	ch       *consistent.Consistent
 	backends map[string]*minio.Client
 
+	transports []*http.Transport
+	secure     bool // customer keys are only sent over TLS
+
 	mu sync.RWMutex
 }
 
@@ -33,6 +37,44 @@ func (b *Backends) Locate(id string) (*minio.Client, string) {
 	return client, backendID
 }
 
+func (b *Backends) LocateN(id string, n int) ([]BackendDef, error) { // distinct members, closest first
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	members, err := b.ch.GetClosestN([]byte(id), n)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]BackendDef, 0, len(members))
+
+	for _, el := range members {
+		client, ok := b.backends[el.String()]
+		if !ok {
+			return nil, ErrNoBackend
+		}
+
+		out = append(out, BackendDef{
+			Name:        el.String(),
+			MinioClient: client,
+		})
+	}
+
+	return out, nil
+}
+
+func (b *Backends) Get(backendID string) (BackendDef, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	client, ok := b.backends[backendID]
+
+	return BackendDef{
+		Name:        backendID,
+		MinioClient: client,
+	}, ok
+}
+
 func (b *Backends) GetMembers() []BackendDef {
 	members := b.ch.GetMembers()
 	out := make([]BackendDef, 0, len(members))
@@ -46,3 +88,14 @@ func (b *Backends) GetMembers() []BackendDef {
 
 	return out
 }
+
+func (b *Backends) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, t := range b.transports {
+		t.CloseIdleConnections()
+	}
+
+	b.transports = nil
+}
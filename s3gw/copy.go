@@ -0,0 +1,226 @@
+package s3gw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+var ErrCopyToSelf = errors.New("source and destination of copy must differ")
+
+type CopyOptions struct {
+	SSEC       encrypt.ServerSide // destination is stored with same customer key as source
+	Conditions WriteConditions    // evaluated against destination
+	Move       bool               // source is removed once destination is written
+}
+
+func (s *Store) CopyObject(ctx context.Context, namespace, srcID, dstID string, opts CopyOptions) (ObjectInfo, error) {
+	if srcID == dstID {
+		return ObjectInfo{}, ErrCopyToSelf
+	}
+
+	err := s.checkSSEC(namespace, opts.SSEC)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	_, _, err = s.locate(namespace, dstID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.lockObjects(namespace, srcID, dstID)() // source is neither replaced nor removed while copied
+
+	err = s.checkConditions(ctx, namespace, dstID, opts.Conditions, opts.SSEC)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	source, err := s.StatObject(ctx, namespace, srcID, GetOptions{SSEC: opts.SSEC})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	var info ObjectInfo
+
+	if source.layout == "" && source.customerKeyMD5 == "" && !source.headCopy { // stored bytes and metadata are valid under any key
+		info, err = s.copyStored(ctx, namespace, srcID, dstID)
+	} else { // manifests reference parts of source, they must not be shared
+		info, err = s.copyStreamed(ctx, namespace, srcID, dstID, opts.SSEC)
+	}
+
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if opts.Move {
+		_, err = s.removeObject(ctx, namespace, srcID)
+		if err != nil {
+			return info, fmt.Errorf("object %q copied to %q, but source was not removed: %w", srcID, dstID, err)
+		}
+	}
+
+	return info, nil
+}
+
+func (s *Store) copyStored(ctx context.Context, namespace, srcID, dstID string) (ObjectInfo, error) { // caller holds both key locks
+	srcClient, srcBackendID, err := s.locate(namespace, srcID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	dstClient, dstBackendID, err := s.locate(namespace, dstID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.invalidateCached(namespace, dstID)
+
+	bucketName := s.BucketName(namespace)
+
+	err = s.ensureBucket(ctx, BackendDef{MinioClient: dstClient, Name: dstBackendID}, bucketName)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to ensure S3 bucket %q existance on %q: %w",
+			bucketName, dstBackendID, err)
+	}
+
+	previous := s.overwrittenManifest(ctx, dstClient, namespace, dstID)
+
+	if srcBackendID == dstBackendID { // backend copies tags along with data
+		_, err = dstClient.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: bucketName, Object: dstID},
+			minio.CopySrcOptions{Bucket: bucketName, Object: srcID},
+		)
+	} else {
+		_, err = CopyObjectAcrossBackends(ctx, srcClient, dstClient, bucketName, srcID, bucketName, dstID)
+		if err == nil {
+			err = s.copyTags(ctx, namespace, srcID, dstID)
+		}
+	}
+
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to copy object %q to %q on %q: %w",
+			srcID, dstID, dstBackendID, s.checkBucketError(dstBackendID, bucketName, err))
+	}
+
+	s.releaseManifest(ctx, previous)
+	s.removeErasureHeadCopies(ctx, namespace, dstID, previous)
+
+	return s.statObject(ctx, namespace, dstID, GetOptions{}) // destination is confirmed before source may be removed
+}
+
+func (s *Store) copyStreamed(ctx context.Context, namespace, srcID, dstID string, sse encrypt.ServerSide) (ObjectInfo, error) { // caller holds both key locks
+	object, err := s.readObject(ctx, namespace, srcID, GetOptions{SSEC: sse})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer object.Close()
+
+	expires := object.Info.Expires // only explicit expiry is carried over, rules apply to destination key
+
+	expected, _ := expectedReadChecksums(object.Info)
+	body := NewChecksumReader(object, expected, object.Info.Size)
+
+	info, err := s.putObject(ctx, namespace, dstID, body, object.Info.Size,
+		PutOptions{
+			ContentType:  object.Info.ContentType,
+			UserMetadata: object.Info.UserMetadata,
+			Checksums:    expected,
+			SSEC:         sse,
+			Expires:      expires,
+		},
+	)
+	if body.Err() != nil {
+		return ObjectInfo{}, fmt.Errorf("object %q: %w", srcID, ErrCorruptedObject)
+	}
+
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	err = s.copyTags(ctx, namespace, srcID, dstID)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to copy tags of object %q to %q: %w", srcID, dstID, err)
+	}
+
+	return info, nil
+}
+
+func (s *Store) copyTags(ctx context.Context, namespace, srcID, dstID string) error {
+	srcClient, _, err := s.locate(namespace, srcID)
+	if err != nil {
+		return err
+	}
+
+	dstClient, _, err := s.locate(namespace, dstID)
+	if err != nil {
+		return err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	t, err := srcClient.GetObjectTagging(ctx, bucketName, srcID, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return err
+	}
+
+	if t.Count() == 0 {
+		return nil
+	}
+
+	return dstClient.PutObjectTagging(ctx, bucketName, dstID, t, minio.PutObjectTaggingOptions{})
+}
+
+func HandleObjectCopy(w http.ResponseWriter, r *http.Request, store *Store, move bool) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	to := r.URL.Query().Get("to")
+	if !IsValidID(to) {
+		http.Error(w,
+			InvalidIDMessage(),
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	if !Authorized(r, ActionWrite, namespace, to) || move && !Authorized(r, ActionRead, namespace, id) {
+		writeObjectError(w, ErrAccessDenied)
+
+		return
+	}
+
+	sse, err := SSECFromRequest(r.Header)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	info, err := store.CopyObject(r.Context(), namespace, id, to,
+		CopyOptions{SSEC: sse, Conditions: WriteConditionsFromRequest(r.Header), Move: move})
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	writeVersionHeader(w, info.VersionID)
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusCreated)
+
+	if move {
+		log.Printf("Object %q moved to %q on %q", id, to, info.Backend)
+	} else {
+		log.Printf("Object %q copied to %q on %q", id, to, info.Backend)
+	}
+}
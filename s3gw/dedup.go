@@ -0,0 +1,450 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	DedupGCIntervalEnvKey = "DEDUP_GC_INTERVAL"
+	DedupGCGraceEnvKey    = "DEDUP_GC_GRACE" // unreferenced blobs, stale references and staged bodies younger than this are kept
+)
+
+const metaRefObject = "Gw-Ref-Object" // namespace and id of object holding reference
+
+const (
+	blobPrefix    = "dedup/blobs/"
+	trashPrefix   = "dedup/trash/" // blobs removed by collector, restored when referenced again
+	refPrefix     = "dedup/refs/"
+	stagingPrefix = "dedup/staging/" // bodies whose hash is not known up front
+)
+
+type DedupRef struct {
+	SHA256 string `json:"sha256"` // hex, blob is only part of manifest
+	Ref    string `json:"ref"`    // reference marker, removed when object is overwritten or deleted
+}
+
+func blobRingKey(sum string) string { // blobs and their references are placed on ring by content hash
+	return "blob#" + sum
+}
+
+func blobRefKey(sum, writeID string) string {
+	return refPrefix + sum + "/" + writeID
+}
+
+func (s *Store) putDedup(ctx context.Context, client *minio.Client, bucketName, namespace, id string, body io.Reader, size int64, opts PutOptions) (minio.UploadInfo, *Manifest, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return minio.UploadInfo{}, nil, err
+	}
+
+	writeID := hex.EncodeToString(b)
+
+	h := md5.New()
+	staged := ""
+
+	sum, err := base64.StdEncoding.DecodeString(opts.Checksums.SHA256) // verified by caller while body is read
+	if err != nil || len(sum) != sha256.Size || size < 0 {
+		sh := sha256.New()
+
+		info, _, err := s.putInternal(ctx, writeID, stagingPrefix+writeID, io.TeeReader(body, io.MultiWriter(sh, h)), size, nil)
+		if err != nil {
+			return minio.UploadInfo{}, nil, err
+		}
+
+		staged = stagingPrefix + writeID
+
+		defer func() {
+			err := s.removeInternal(ctx, writeID, staged)
+			if err != nil {
+				log.Printf("Failed to remove staged body %q, it is left for collector: %v", staged, err)
+			}
+		}()
+
+		sum, size = sh.Sum(nil), info.Size
+	}
+
+	d := &DedupRef{
+		SHA256: hex.EncodeToString(sum),
+		Ref:    blobRefKey(hex.EncodeToString(sum), writeID),
+	}
+
+	err = s.holdBlob(ctx, namespace, id, d, false) // taken before blob is checked, collector restores blob removed meanwhile
+	if err != nil {
+		return minio.UploadInfo{}, nil, err
+	}
+
+	m := &Manifest{
+		Size:  size,
+		Dedup: d,
+	}
+
+	blob, err := s.ensureBlob(ctx, d.SHA256, io.TeeReader(body, h), size, staged, writeID)
+	if err == nil { // transfer may have outlived grace period, reference was then taken for stale
+		err = s.holdBlob(ctx, namespace, id, d, true)
+	}
+
+	if err != nil {
+		s.releaseManifest(ctx, m)
+
+		return minio.UploadInfo{}, nil, fmt.Errorf("failed to store blob of object %q: %w", id, err)
+	}
+
+	m.ETag = hex.EncodeToString(h.Sum(nil))
+	m.Parts = []ManifestPart{{
+		RingKey: blobRingKey(d.SHA256),
+		Key:     blobPrefix + d.SHA256,
+		Size:    blob.Size,
+		ETag:    blob.ETag,
+	}}
+
+	opts.Checksums.SHA256 = base64.StdEncoding.EncodeToString(sum)
+
+	info, err := s.putManifest(ctx, client, bucketName, id, m, opts)
+	if err != nil {
+		s.releaseManifest(ctx, m)
+
+		return minio.UploadInfo{}, nil, err
+	}
+
+	return info, m, nil
+}
+
+func (s *Store) holdBlob(ctx context.Context, namespace, id string, d *DedupRef, stored bool) error { // rewriting reference restores blob moved to trash
+	client, backendID := s.backends.Locate(blobRingKey(d.SHA256))
+	if client == nil {
+		return ErrNoBackend
+	}
+
+	bDef := BackendDef{MinioClient: client, Name: backendID}
+
+	_, err := s.putInternalAt(ctx, bDef, d.Ref, bytes.NewReader(nil), 0,
+		map[string]string{metaRefObject: namespace + "/" + id})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.statInternalAt(ctx, bDef, blobPrefix+d.SHA256)
+	if errors.Is(err, ErrObjectNotFound) {
+		_, err = s.restoreBlob(ctx, bDef, d.SHA256)
+	}
+
+	if errors.Is(err, ErrObjectNotFound) { // neither blob nor trash
+		if !stored { // first hold of new blob
+			return nil
+		}
+
+		return fmt.Errorf("blob %q was collected during upload", d.SHA256) // not found would be reported for uploaded object
+	}
+
+	return err
+}
+
+func (s *Store) ensureBlob(ctx context.Context, sum string, body io.Reader, size int64, staged, writeID string) (minio.ObjectInfo, error) {
+	client, backendID := s.backends.Locate(blobRingKey(sum))
+	if client == nil {
+		return minio.ObjectInfo{}, ErrNoBackend
+	}
+
+	bDef := BackendDef{MinioClient: client, Name: backendID}
+
+	info, err := s.statInternalAt(ctx, bDef, blobPrefix+sum)
+	if errors.Is(err, ErrObjectNotFound) {
+		info, err = s.restoreBlob(ctx, bDef, sum)
+	}
+
+	switch {
+	case err == nil: // body is only read to verify checksums and compute ETag
+		if staged == "" {
+			_, err = io.Copy(io.Discard, body)
+		}
+
+		return info, err
+	case !errors.Is(err, ErrObjectNotFound):
+		return minio.ObjectInfo{}, err
+	}
+
+	if staged == "" {
+		_, err = s.putInternalAt(ctx, bDef, blobPrefix+sum, body, size, nil)
+	} else {
+		err = s.copyInternal(ctx, writeID, staged, bDef, blobPrefix+sum)
+	}
+
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	return s.statInternalAt(ctx, bDef, blobPrefix+sum)
+}
+
+func (s *Store) copyInternal(ctx context.Context, srcRingKey, srcKey string, dst BackendDef, dstKey string) error {
+	src, srcID := s.backends.Locate(srcRingKey)
+	if src == nil {
+		return ErrNoBackend
+	}
+
+	if srcID == dst.Name {
+		_, err := dst.MinioClient.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: s.internalBucketName, Object: dstKey},
+			minio.CopySrcOptions{Bucket: s.internalBucketName, Object: srcKey},
+		)
+
+		return err
+	}
+
+	_, err := CopyObjectAcrossBackends(ctx, src, dst.MinioClient, s.internalBucketName, srcKey, s.internalBucketName, dstKey)
+
+	return err
+}
+
+func (s *Store) restoreBlob(ctx context.Context, bDef BackendDef, sum string) (minio.ObjectInfo, error) {
+	_, err := bDef.MinioClient.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.internalBucketName, Object: blobPrefix + sum},
+		minio.CopySrcOptions{Bucket: s.internalBucketName, Object: trashPrefix + sum},
+	)
+	if err != nil {
+		return minio.ObjectInfo{}, objectError(err, trashPrefix+sum, bDef.Name)
+	}
+
+	return s.statInternalAt(ctx, bDef, blobPrefix+sum)
+}
+
+func RunDedupGC(ctx context.Context, store *Store) {
+	interval := MustGetDurationFromEnv(DedupGCIntervalEnvKey)
+	grace := MustGetDurationFromEnv(DedupGCGraceEnvKey)
+
+	if interval <= 0 {
+		log.Printf("Dedup blob garbage collection is disabled")
+
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, bDef := range store.backends.GetMembers() {
+			stats, err := store.collectBlobs(ctx, bDef, grace)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Dedup blob garbage collection on %q failed: %v", bDef.Name, err)
+			}
+
+			if stats.removed > 0 || stats.staleRefs > 0 {
+				log.Printf("Removed %d unreferenced blobs and %d stale references from %q",
+					stats.removed, stats.staleRefs, bDef.Name)
+			}
+		}
+	}
+}
+
+type blobGCStats struct {
+	removed   int
+	staleRefs int
+}
+
+func (s *Store) collectBlobs(ctx context.Context, bDef BackendDef, grace time.Duration) (blobGCStats, error) { // blobs and their references share a backend
+	var stats blobGCStats
+
+	exists, err := s.bucketExists(ctx, bDef, s.internalBucketName)
+	if err != nil || !exists {
+		return stats, err
+	}
+
+	refs, err := s.liveRefs(ctx, bDef, grace, &stats)
+	if err != nil {
+		return stats, err
+	}
+
+	staging, err := ListObjectsInBucket(ctx, bDef.MinioClient, s.internalBucketName, stagingPrefix, "", 0)
+	if err != nil {
+		return stats, err
+	}
+
+	var errs []error
+
+	for _, object := range staging { // uploads that died before removing their staged body
+		if time.Since(object.LastModified) >= grace {
+			errs = append(errs, s.removeInternalAt(ctx, bDef, object.Key))
+		}
+	}
+
+	blobs, err := ListObjectsInBucket(ctx, bDef.MinioClient, s.internalBucketName, blobPrefix, "", 0)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, blob := range blobs {
+		sum := strings.TrimPrefix(blob.Key, blobPrefix)
+		if refs[sum] > 0 || time.Since(blob.LastModified) < grace {
+			continue
+		}
+
+		removed, err := s.removeBlob(ctx, bDef, sum)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if removed {
+			stats.removed++
+		}
+	}
+
+	trash, err := ListObjectsInBucket(ctx, bDef.MinioClient, s.internalBucketName, trashPrefix, "", 0)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, object := range trash { // kept for grace period, uploads referencing blob meanwhile restore it
+		if time.Since(object.LastModified) < grace {
+			continue
+		}
+
+		sum := strings.TrimPrefix(object.Key, trashPrefix)
+
+		n, err := s.countRefs(ctx, bDef, sum)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if n > 0 {
+			_, err = s.statInternalAt(ctx, bDef, blobPrefix+sum)
+			if errors.Is(err, ErrObjectNotFound) {
+				_, err = s.restoreBlob(ctx, bDef, sum)
+			}
+
+			if err != nil {
+				errs = append(errs, err)
+
+				continue
+			}
+		}
+
+		errs = append(errs, s.removeInternalAt(ctx, bDef, object.Key))
+	}
+
+	return stats, errors.Join(errs...)
+}
+
+func (s *Store) removeBlob(ctx context.Context, bDef BackendDef, sum string) (bool, error) {
+	_, err := bDef.MinioClient.CopyObject(ctx, // blob is moved to trash first, delete is not conditional
+		minio.CopyDestOptions{Bucket: s.internalBucketName, Object: trashPrefix + sum},
+		minio.CopySrcOptions{Bucket: s.internalBucketName, Object: blobPrefix + sum},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to move blob %q to trash: %w", sum, err)
+	}
+
+	err = s.removeInternalAt(ctx, bDef, blobPrefix+sum)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := s.countRefs(ctx, bDef, sum) // upload may have found blob right before removal
+	if err == nil && n == 0 {
+		return true, nil
+	}
+
+	_, restoreErr := s.restoreBlob(ctx, bDef, sum)
+	if restoreErr != nil {
+		return true, fmt.Errorf("failed to restore blob %q referenced during removal, trash is restored by next run: %w", sum, restoreErr)
+	}
+
+	return false, err
+}
+
+func (s *Store) countRefs(ctx context.Context, bDef BackendDef, sum string) (int, error) {
+	refs, err := ListObjectsInBucket(ctx, bDef.MinioClient, s.internalBucketName, refPrefix+sum+"/", "", 0)
+
+	return len(refs), err
+}
+
+func (s *Store) liveRefs(ctx context.Context, bDef BackendDef, grace time.Duration, stats *blobGCStats) (map[string]int, error) { // reference counts by blob hash
+	refs, err := ListObjectsInBucket(ctx, bDef.MinioClient, s.internalBucketName, refPrefix, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+
+	for _, ref := range refs {
+		sum, _, _ := strings.Cut(strings.TrimPrefix(ref.Key, refPrefix), "/")
+
+		if time.Since(ref.LastModified) >= grace && s.isStaleRef(ctx, bDef, ref) {
+			err = s.removeInternalAt(ctx, bDef, ref.Key)
+			if err == nil {
+				stats.staleRefs++
+
+				continue
+			}
+		}
+
+		counts[sum]++
+	}
+
+	return counts, nil
+}
+
+func (s *Store) isStaleRef(ctx context.Context, bDef BackendDef, ref minio.ObjectInfo) bool { // holder failed before writing manifest or releasing reference
+	holder := metaValue(ref.UserMetadata, metaRefObject)
+	if holder == "" { // listings do not always carry metadata
+		info, err := s.statInternalAt(ctx, bDef, ref.Key)
+		if err != nil {
+			return false
+		}
+
+		holder = metaValue(info.UserMetadata, metaRefObject)
+	}
+
+	namespace, id, ok := strings.Cut(holder, "/")
+	if !ok {
+		return false
+	}
+
+	client, _, err := s.locate(namespace, id)
+	if err != nil {
+		return false
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	info, err := client.StatObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	if err != nil {
+		code := minio.ToErrorResponse(err).Code
+
+		return code == "NoSuchKey" || code == "NoSuchBucket"
+	}
+
+	if metaValue(info.UserMetadata, metaLayout) != layoutManifest {
+		return true
+	}
+
+	m, err := s.readManifest(ctx, client, bucketName, id, "")
+	if err != nil {
+		return false
+	}
+
+	return m.Dedup == nil || m.Dedup.Ref != ref.Key
+}
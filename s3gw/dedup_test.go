@@ -0,0 +1,240 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const testDedupNamespace = "artifacts"
+
+func newTestDedupStore(t *testing.T) *Store {
+	t.Helper()
+
+	return newTestStore(t, newTestBackends(t, 3), map[string]NamespaceConfig{
+		testDedupNamespace: {Storage: StorageDedup},
+	})
+}
+
+func countInternal(t *testing.T, s *Store, prefix string) int {
+	t.Helper()
+
+	n := 0
+
+	for _, bDef := range s.backends.GetMembers() {
+		objects, err := ListObjectsInBucket(context.Background(), bDef.MinioClient, s.internalBucketName, prefix, "", 0)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" { // backend never got internal object
+			t.Fatalf("Failed to list %q on %q: %v", prefix, bDef.Name, err)
+		}
+
+		n += len(objects)
+	}
+
+	return n
+}
+
+func putDedupObject(t *testing.T, s *Store, id string, body []byte, withChecksum bool) {
+	t.Helper()
+
+	opts := PutOptions{}
+	if withChecksum { // blob is looked up by declared hash, otherwise body is staged first
+		sum := sha256.Sum256(body)
+		opts.Checksums.SHA256 = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	size := int64(len(body))
+
+	_, err := s.PutObject(context.Background(), testDedupNamespace, id, NewChecksumReader(bytes.NewReader(body), opts.Checksums, size), size, opts)
+	if err != nil {
+		t.Fatalf("Failed to put object %q: %v", id, err)
+	}
+}
+
+func expectDedupObject(t *testing.T, s *Store, id string, body []byte) {
+	t.Helper()
+
+	object, err := s.GetObject(context.Background(), testDedupNamespace, id, GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get object %q: %v", id, err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("Failed to read object %q: %v", id, err)
+	}
+
+	if !bytes.Equal(data, body) {
+		t.Errorf("Expected body of object %q to match, got %d bytes", id, len(data))
+	}
+}
+
+func collectAllBlobs(t *testing.T, s *Store) blobGCStats {
+	t.Helper()
+
+	var total blobGCStats
+
+	for _, bDef := range s.backends.GetMembers() {
+		stats, err := s.collectBlobs(context.Background(), bDef, 0)
+		if err != nil {
+			t.Fatalf("Failed to collect blobs on %q: %v", bDef.Name, err)
+		}
+
+		total.removed += stats.removed
+		total.staleRefs += stats.staleRefs
+	}
+
+	return total
+}
+
+func TestDedupReferenceCounting(t *testing.T) {
+	s := newTestDedupStore(t)
+	ctx := context.Background()
+
+	body := bytes.Repeat([]byte("artifact-"), 10000)
+	sum := sha256.Sum256(body)
+	refs := refPrefix + hex.EncodeToString(sum[:]) + "/"
+
+	putDedupObject(t, s, "a", body, true)
+	putDedupObject(t, s, "b", body, true)
+	putDedupObject(t, s, "c", body, false)
+
+	for prefix, expected := range map[string]int{blobPrefix: 1, refs: 3, stagingPrefix: 0} {
+		if n := countInternal(t, s, prefix); n != expected {
+			t.Errorf("Expected %d objects under %q, got %d", expected, prefix, n)
+		}
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		expectDedupObject(t, s, id, body)
+	}
+
+	putDedupObject(t, s, "a", []byte("other content"), true) // overwrite releases reference
+
+	if n := countInternal(t, s, refs); n != 2 {
+		t.Errorf("Expected 2 references after overwrite, got %d", n)
+	}
+
+	for _, id := range []string{"b", "c"} {
+		_, err := s.RemoveObject(ctx, testDedupNamespace, id, WriteConditions{})
+		if err != nil {
+			t.Fatalf("Failed to remove object %q: %v", id, err)
+		}
+	}
+
+	if n := countInternal(t, s, refs); n != 0 {
+		t.Errorf("Expected no references after removal, got %d", n)
+	}
+
+	if stats := collectAllBlobs(t, s); stats.removed != 1 {
+		t.Errorf("Expected unreferenced blob to be collected, got %+v", stats)
+	}
+
+	if n := countInternal(t, s, blobPrefix); n != 1 { // blob of overwritten "a" is still referenced
+		t.Errorf("Expected 1 blob after collection, got %d", n)
+	}
+
+	expectDedupObject(t, s, "a", []byte("other content"))
+
+	collectAllBlobs(t, s) // trash outlived grace period
+
+	if n := countInternal(t, s, trashPrefix); n != 0 {
+		t.Errorf("Expected trash to be emptied, got %d objects", n)
+	}
+}
+
+func TestDedupCollectorKeepsReferencedBlobs(t *testing.T) {
+	s := newTestDedupStore(t)
+	ctx := context.Background()
+
+	body := bytes.Repeat([]byte("shared-"), 10000)
+
+	putDedupObject(t, s, "a", body, true)
+
+	_, err := s.RemoveObject(ctx, testDedupNamespace, "a", WriteConditions{})
+	if err != nil {
+		t.Fatalf("Failed to remove object: %v", err)
+	}
+
+	sum := sha256.Sum256(body)
+	client, backendID := s.backends.Locate(blobRingKey(hex.EncodeToString(sum[:])))
+
+	removed, err := s.removeBlob(ctx, BackendDef{MinioClient: client, Name: backendID}, hex.EncodeToString(sum[:])) // trash is kept for grace period
+	if err != nil || !removed {
+		t.Fatalf("Expected unreferenced blob to be removed, got %v: %v", removed, err)
+	}
+
+	if n := countInternal(t, s, trashPrefix); n != 1 {
+		t.Fatalf("Expected blob in trash, got %d", n)
+	}
+
+	putDedupObject(t, s, "b", body, true) // upload restores blob from trash
+
+	expectDedupObject(t, s, "b", body)
+
+	if stats := collectAllBlobs(t, s); stats.removed != 0 {
+		t.Errorf("Expected referenced blob to be kept, got %+v", stats)
+	}
+
+	expectDedupObject(t, s, "b", body)
+
+	missing := strings.Repeat("0", 64)
+
+	err = s.holdBlob(ctx, testDedupNamespace, "missing", &DedupRef{SHA256: missing, Ref: blobRefKey(missing, "stale")}, false)
+	if err != nil {
+		t.Fatalf("Failed to hold blob: %v", err)
+	}
+
+	if stats := collectAllBlobs(t, s); stats.staleRefs != 1 { // holder of reference never wrote its manifest
+		t.Errorf("Expected stale reference to be removed, got %+v", stats)
+	}
+}
+
+func TestDedupBlobCollectedDuringUpload(t *testing.T) {
+	s := newTestDedupStore(t)
+	ctx := context.Background()
+
+	body := []byte("body of interrupted upload")
+	sum := sha256.Sum256(body)
+	d := &DedupRef{SHA256: hex.EncodeToString(sum[:]), Ref: blobRefKey(hex.EncodeToString(sum[:]), "upload")}
+
+	err := s.holdBlob(ctx, testDedupNamespace, "a", d, false) // first hold of new blob
+	if err != nil {
+		t.Fatalf("Expected first hold of missing blob to succeed, got %v", err)
+	}
+
+	_, err = s.ensureBlob(ctx, d.SHA256, bytes.NewReader(body), int64(len(body)), "", "upload")
+	if err != nil {
+		t.Fatalf("Failed to store blob: %v", err)
+	}
+
+	client, backendID := s.backends.Locate(blobRingKey(d.SHA256))
+	bDef := BackendDef{MinioClient: client, Name: backendID}
+
+	err = s.removeInternalAt(ctx, bDef, blobPrefix+d.SHA256) // collector took reference for stale and emptied trash meanwhile
+	if err != nil {
+		t.Fatalf("Failed to remove blob: %v", err)
+	}
+
+	err = s.holdBlob(ctx, testDedupNamespace, "a", d, true)
+	if err == nil {
+		t.Fatalf("Expected hold of collected blob to fail")
+	}
+
+	_, err = s.ensureBlob(ctx, d.SHA256, bytes.NewReader(body), int64(len(body)), "", "retry") // retried upload stores blob again
+	if err != nil {
+		t.Fatalf("Failed to store blob again: %v", err)
+	}
+
+	err = s.holdBlob(ctx, testDedupNamespace, "a", d, true)
+	if err != nil {
+		t.Errorf("Expected hold of stored blob to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,482 @@
+package s3gw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	DiskCacheDirEnvKey           = "DISK_CACHE_DIR" // empty disables disk cache
+	DiskCacheSizeEnvKey          = "DISK_CACHE_SIZE"
+	DiskCacheMinObjectSizeEnvKey = "DISK_CACHE_MIN_OBJECT_SIZE"
+	DiskCacheEvictionEnvKey      = "DISK_CACHE_EVICTION" // lru or lfu
+)
+
+const (
+	DiskCacheEvictionLRU = "lru"
+	DiskCacheEvictionLFU = "lfu"
+)
+
+const (
+	diskCacheDataExt    = ".data"
+	diskCacheMetaExt    = ".meta"
+	diskCacheFillPrefix = ".fill-"
+)
+
+var (
+	diskCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "disk_cache",
+		Name:      "hits_total",
+		Help:      "Object reads served from disk cache.",
+	})
+	diskCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "disk_cache",
+		Name:      "misses_total",
+		Help:      "Object reads not found in disk cache or with outdated ETag.",
+	})
+	diskCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "disk_cache",
+		Name:      "evictions_total",
+		Help:      "Cached files removed to stay within size limit.",
+	})
+	diskCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "disk_cache",
+		Name:      "bytes",
+		Help:      "Size of cached files.",
+	})
+)
+
+type diskCacheMeta struct { // persisted next to data file, index is rebuilt from it
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	ETag      string `json:"etag"`
+	Size      int64  `json:"size"`
+}
+
+type diskCacheEntry struct {
+	diskCacheMeta
+
+	lastUsed time.Time
+	hits     int64
+}
+
+type DiskCache struct {
+	dir           string
+	maxSize       int64
+	minObjectSize int64
+	eviction      string
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*diskCacheEntry // by file name
+}
+
+func OpenDiskCache() (*DiskCache, error) { // nil disk cache means that it is disabled
+	dir := os.Getenv(DiskCacheDirEnvKey)
+	if dir == "" {
+		return nil, nil
+	}
+
+	c := &DiskCache{
+		dir:           dir,
+		maxSize:       int64(MustGetIntFromEnv(DiskCacheSizeEnvKey)),
+		minObjectSize: int64(MustGetIntFromEnv(DiskCacheMinObjectSizeEnvKey)),
+		eviction:      os.Getenv(DiskCacheEvictionEnvKey),
+		entries:       make(map[string]*diskCacheEntry),
+	}
+
+	switch c.eviction {
+	case "":
+		c.eviction = DiskCacheEvictionLRU
+	case DiskCacheEvictionLRU, DiskCacheEvictionLFU:
+	default:
+		return nil, fmt.Errorf("unknown disk cache eviction policy %q", c.eviction)
+	}
+
+	if c.maxSize <= 0 {
+		return nil, fmt.Errorf("disk cache size must be positive when %s is set", DiskCacheDirEnvKey)
+	}
+
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk cache directory: %w", err)
+	}
+
+	err = c.rebuildIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild disk cache index in %q: %w", dir, err)
+	}
+
+	log.Printf("Disk cache in %q holds %d objects, %d bytes", dir, len(c.entries), c.size)
+
+	return c, nil
+}
+
+func (c *DiskCache) rebuildIndex() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+
+		switch {
+		case strings.HasPrefix(name, diskCacheFillPrefix): // interrupted fill
+			os.Remove(filepath.Join(c.dir, name))
+		case strings.HasSuffix(name, diskCacheMetaExt):
+			entry, err := c.loadEntry(strings.TrimSuffix(name, diskCacheMetaExt))
+			if err != nil {
+				log.Printf("Dropping disk cache entry %q: %v", name, err)
+				c.removeFiles(strings.TrimSuffix(name, diskCacheMetaExt))
+
+				continue
+			}
+
+			c.entries[strings.TrimSuffix(name, diskCacheMetaExt)] = entry
+			c.size += entry.Size
+		case strings.HasSuffix(name, diskCacheDataExt):
+			if _, err := os.Stat(filepath.Join(c.dir, strings.TrimSuffix(name, diskCacheDataExt)+diskCacheMetaExt)); err != nil {
+				os.Remove(filepath.Join(c.dir, name)) // crashed between renames
+			}
+		}
+	}
+
+	c.evict()
+
+	return nil
+}
+
+func (c *DiskCache) loadEntry(file string) (*diskCacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, file+diskCacheMetaExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry diskCacheEntry
+
+	err = json.Unmarshal(data, &entry.diskCacheMeta)
+	if err != nil {
+		return nil, err
+	}
+
+	if diskCacheFile(entry.Namespace, entry.ID) != file {
+		return nil, fmt.Errorf("metadata does not match file name")
+	}
+
+	fi, err := os.Stat(filepath.Join(c.dir, file+diskCacheDataExt))
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() != entry.Size {
+		return nil, fmt.Errorf("data file has %d bytes, expected %d", fi.Size(), entry.Size)
+	}
+
+	entry.lastUsed = fi.ModTime()
+
+	return &entry, nil
+}
+
+func diskCacheFile(namespace, id string) string {
+	sum := sha256.Sum256([]byte(namespace + "\x00" + id))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *DiskCache) cacheable(size int64) bool {
+	return c != nil && size >= c.minObjectSize && size <= c.maxSize
+}
+
+func (c *DiskCache) has(namespace, id string) bool { // any version, ETag is not known yet
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.entries[diskCacheFile(namespace, id)]
+
+	return ok
+}
+
+func (c *DiskCache) open(namespace, id, etag string) (*os.File, bool) { // ETag comes from fresh StatObject
+	if c == nil {
+		return nil, false
+	}
+
+	file := diskCacheFile(namespace, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[file]
+	if !ok || entry.ETag != etag {
+		diskCacheMisses.Inc()
+
+		return nil, false
+	}
+
+	f, err := os.Open(filepath.Join(c.dir, file+diskCacheDataExt)) // stays readable after eviction removes it
+	if err != nil {
+		log.Printf("Failed to open cached object %q: %v", id, err)
+		c.removeEntry(file)
+		diskCacheMisses.Inc()
+
+		return nil, false
+	}
+
+	entry.lastUsed = time.Now()
+	entry.hits++
+	diskCacheHits.Inc()
+
+	return f, true
+}
+
+func (c *DiskCache) reader(f *os.File, info ObjectInfo, br *ByteRange) (*ObjectReader, error) {
+	offset, length, err := br.Resolve(info.Size)
+	if err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	return &ObjectReader{
+		ReadCloser: struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, offset, length), f},
+		Info:   info,
+		Offset: offset,
+		Length: length,
+	}, nil
+}
+
+func (c *DiskCache) invalidate(namespace, id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeEntry(diskCacheFile(namespace, id))
+}
+
+func (c *DiskCache) removeEntry(file string) {
+	if entry, ok := c.entries[file]; ok {
+		c.size -= entry.Size
+		delete(c.entries, file)
+		diskCacheBytes.Set(float64(c.size))
+	}
+
+	c.removeFiles(file)
+}
+
+func (c *DiskCache) removeFiles(file string) {
+	os.Remove(filepath.Join(c.dir, file+diskCacheMetaExt)) // meta first, data without meta is dropped on restart
+	os.Remove(filepath.Join(c.dir, file+diskCacheDataExt))
+}
+
+func (c *DiskCache) evict() {
+	for c.size > c.maxSize {
+		var (
+			victimFile string
+			victim     *diskCacheEntry
+		)
+
+		for file, entry := range c.entries {
+			if victim == nil || c.evictsBefore(entry, victim) {
+				victimFile, victim = file, entry
+			}
+		}
+
+		c.removeEntry(victimFile)
+		diskCacheEvictions.Inc()
+	}
+
+	diskCacheBytes.Set(float64(c.size))
+}
+
+func (c *DiskCache) evictsBefore(a, b *diskCacheEntry) bool {
+	if c.eviction == DiskCacheEvictionLFU && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+
+	return a.lastUsed.Before(b.lastUsed)
+}
+
+func (c *DiskCache) commit(tmp *os.File, meta diskCacheMeta) error {
+	file := diskCacheFile(meta.Namespace, meta.ID)
+
+	err := tmp.Sync()
+	if err != nil {
+		return err
+	}
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	metaTmp, err := os.CreateTemp(c.dir, diskCacheFillPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metaTmp.Name())
+
+	_, err = metaTmp.Write(metaData)
+	if err == nil {
+		err = metaTmp.Sync()
+	}
+
+	if closeErr := metaTmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeEntry(file)
+
+	err = os.Rename(tmp.Name(), filepath.Join(c.dir, file+diskCacheDataExt))
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(metaTmp.Name(), filepath.Join(c.dir, file+diskCacheMetaExt))
+	if err != nil {
+		os.Remove(filepath.Join(c.dir, file+diskCacheDataExt))
+
+		return err
+	}
+
+	c.entries[file] = &diskCacheEntry{
+		diskCacheMeta: meta,
+		lastUsed:      time.Now(),
+	}
+	c.size += meta.Size
+
+	c.evict()
+
+	return nil
+}
+
+type diskCacheFill struct { // tees first whole object read into cache file
+	io.ReadCloser
+
+	cache  *DiskCache
+	meta   diskCacheMeta
+	tmp    *os.File
+	hashes *checksumHashes
+	verify bool
+
+	written int64
+}
+
+func (c *DiskCache) tee(namespace, id string, object *ObjectReader) {
+	if c == nil || !object.whole() || object.Info.envelope != nil { // plaintext of encrypted objects must not reach local disk
+		return
+	}
+
+	tmp, err := os.CreateTemp(c.dir, diskCacheFillPrefix)
+	if err != nil {
+		log.Printf("Failed to create disk cache file for object %q: %v", id, err)
+
+		return
+	}
+
+	expected, verify := expectedReadChecksums(object.Info)
+
+	object.ReadCloser = &diskCacheFill{
+		ReadCloser: object.ReadCloser,
+		cache:      c,
+		meta: diskCacheMeta{
+			Namespace: namespace,
+			ID:        id,
+			ETag:      object.Info.ETag,
+			Size:      object.Info.Size,
+		},
+		tmp:    tmp,
+		hashes: newChecksumHashes(expected),
+		verify: verify,
+	}
+}
+
+func (f *diskCacheFill) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+
+	if f.tmp != nil && n > 0 {
+		f.hashes.Write(p[:n])
+		f.written += int64(n)
+
+		if _, werr := f.tmp.Write(p[:n]); werr != nil {
+			log.Printf("Failed to write disk cache file for object %q: %v", f.meta.ID, werr)
+			f.abort()
+		}
+	}
+
+	if f.tmp != nil && f.written == f.meta.Size && (err == nil || err == io.EOF) {
+		f.finish()
+	}
+
+	return n, err
+}
+
+func (f *diskCacheFill) finish() {
+	tmp := f.tmp
+	f.tmp = nil
+
+	if f.verify && !f.hashes.verify() {
+		log.Printf("Object %q served by backend does not match stored checksum, not caching", f.meta.ID)
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return
+	}
+
+	err := f.cache.commit(tmp, f.meta)
+	tmp.Close()
+
+	if err != nil {
+		log.Printf("Failed to store object %q in disk cache: %v", f.meta.ID, err)
+		os.Remove(tmp.Name())
+	}
+}
+
+func (f *diskCacheFill) abort() {
+	if f.tmp == nil {
+		return
+	}
+
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+	f.tmp = nil
+}
+
+func (f *diskCacheFill) Close() error {
+	f.abort() // client went away before whole object was read
+
+	return f.ReadCloser.Close()
+}
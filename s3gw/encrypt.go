@@ -0,0 +1,548 @@
+package s3gw
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	EncryptionMasterKeyFileEnvKey = "ENCRYPTION_MASTER_KEY_FILE" // "<id> <base64 key>" per line, first key wraps new data keys
+	EncryptionKMSEndpointEnvKey   = "ENCRYPTION_KMS_ENDPOINT"    // KES style key service, alternative to key file
+	EncryptionKMSKeyEnvKey        = "ENCRYPTION_KMS_KEY"         // name of KMS key wrapping new data keys
+	EncryptionKMSTokenEnvKey      = "ENCRYPTION_KMS_TOKEN"       // optional bearer token
+)
+
+const (
+	EncryptionNone     = "none"
+	EncryptionEnvelope = "envelope" // per-object data key wrapped by master key
+)
+
+const ( // internal object metadata of encrypted objects and parts, stored size includes GCM tags
+	metaEncryption  = "Gw-Encryption"
+	metaDataKey     = "Gw-Data-Key"   // base64 of wrapped data key
+	metaMasterKeyID = "Gw-Master-Key" // ID of master key that wrapped data key
+)
+
+const (
+	encryptionScheme    = "aes-256-gcm-64k"
+	encryptionChunkSize = 64 << 10 // plaintext bytes sealed together, ranges are read in whole chunks
+	encryptionOverhead  = 16       // GCM tag per chunk
+	dataKeySize         = 32
+)
+
+var (
+	ErrDecryptionFailed = errors.New("encrypted object data failed authentication")
+
+	errNoMasterKey      = errors.New("no master key configured")
+	errUnknownMasterKey = errors.New("unknown master key")
+
+	masterKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
+
+type envelope struct { // data key of encrypted object or part as stored in metadata
+	scheme  string
+	keyID   string
+	dataKey string
+}
+
+func envelopeFromMetadata(m map[string]string) *envelope { // nil for plaintext objects
+	if metaValue(m, metaEncryption) == "" {
+		return nil
+	}
+
+	return &envelope{
+		scheme:  metaValue(m, metaEncryption),
+		keyID:   metaValue(m, metaMasterKeyID),
+		dataKey: metaValue(m, metaDataKey),
+	}
+}
+
+func (e *envelope) metadata(m map[string]string) {
+	m[metaEncryption] = e.scheme
+	m[metaMasterKeyID] = e.keyID
+	m[metaDataKey] = e.dataKey
+}
+
+type KeyManager struct { // wraps and unwraps data keys, master keys never leave it
+	current string
+
+	keys map[string]cipher.AEAD // master keys loaded from file, by ID
+
+	kmsEndpoint string
+	kmsToken    string
+	httpClient  *http.Client
+}
+
+func OpenKeyManager(namespaces *NamespaceConfigs) (*KeyManager, error) { // nil when no master key is configured
+	keyFile := os.Getenv(EncryptionMasterKeyFileEnvKey)
+	kmsEndpoint := os.Getenv(EncryptionKMSEndpointEnvKey)
+
+	switch {
+	case keyFile != "" && kmsEndpoint != "":
+		return nil, fmt.Errorf("master key file and KMS endpoint are mutually exclusive")
+	case keyFile != "":
+		return loadMasterKeys(keyFile)
+	case kmsEndpoint != "":
+		key := os.Getenv(EncryptionKMSKeyEnvKey)
+		if !masterKeyIDPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid KMS key name %q", key)
+		}
+
+		return &KeyManager{
+			current:     key,
+			kmsEndpoint: strings.TrimSuffix(kmsEndpoint, "/"),
+			kmsToken:    os.Getenv(EncryptionKMSTokenEnvKey),
+			httpClient:  &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	}
+
+	for name, nc := range namespaces.Namespaces {
+		if nc.encrypted() {
+			return nil, fmt.Errorf("namespace %q requires encryption: %w", name, errNoMasterKey)
+		}
+	}
+
+	return nil, nil
+}
+
+func loadMasterKeys(path string) (*KeyManager, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	km := &KeyManager{
+		keys: make(map[string]cipher.AEAD),
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, _ := strings.Cut(line, " ")
+		if !masterKeyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid master key ID on line %d of %q", i+1, path)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %q in %q must be 32 bytes encoded as base64", id, path)
+		}
+
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := km.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate master key %q in %q", id, path)
+		}
+
+		km.keys[id] = aead
+
+		if km.current == "" {
+			km.current = id
+		}
+	}
+
+	if km.current == "" {
+		return nil, fmt.Errorf("master key file %q holds no keys", path)
+	}
+
+	return km, nil
+}
+
+func (km *KeyManager) CurrentKeyID() string {
+	if km == nil {
+		return ""
+	}
+
+	return km.current
+}
+
+func (km *KeyManager) newEnvelope(ctx context.Context) (*envelope, []byte, error) { // fresh data key for one object or part
+	if km == nil {
+		return nil, nil, errNoMasterKey
+	}
+
+	dataKey := make([]byte, dataKeySize)
+
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env, err := km.wrap(ctx, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return env, dataKey, nil
+}
+
+func (km *KeyManager) wrap(ctx context.Context, dataKey []byte) (*envelope, error) {
+	var wrapped []byte
+
+	if km.kmsEndpoint != "" {
+		var err error
+
+		wrapped, err = km.kmsCall(ctx, "encrypt", km.current, dataKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		aead := km.keys[km.current]
+
+		nonce := make([]byte, aead.NonceSize())
+
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		wrapped = aead.Seal(nonce, nonce, dataKey, []byte(km.current)) // key ID is authenticated
+	}
+
+	return &envelope{
+		scheme:  encryptionScheme,
+		keyID:   km.current,
+		dataKey: base64.StdEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+func (km *KeyManager) unwrap(ctx context.Context, env *envelope) ([]byte, error) {
+	if km == nil {
+		return nil, errNoMasterKey
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(env.dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %w", err)
+	}
+
+	if km.kmsEndpoint != "" {
+		return km.kmsCall(ctx, "decrypt", env.keyID, wrapped)
+	}
+
+	aead, ok := km.keys[env.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownMasterKey, env.keyID)
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped data key")
+	}
+
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(env.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key %q: %w", env.keyID, err)
+	}
+
+	return dataKey, nil
+}
+
+func (km *KeyManager) rewrap(ctx context.Context, env *envelope) (*envelope, error) {
+	dataKey, err := km.unwrap(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return km.wrap(ctx, dataKey)
+}
+
+func (km *KeyManager) kmsCall(ctx context.Context, op, keyID string, in []byte) ([]byte, error) { // POST /v1/key/{encrypt,decrypt}/{name}
+	field, outField := "plaintext", "ciphertext"
+	if op == "decrypt" {
+		field, outField = outField, field
+	}
+
+	body, err := json.Marshal(map[string][]byte{field: in})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		km.kmsEndpoint+"/v1/key/"+op+"/"+url.PathEscape(keyID), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if km.kmsToken != "" {
+		req.Header.Set("Authorization", "Bearer "+km.kmsToken)
+	}
+
+	resp, err := km.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s data key with KMS key %q: %w", op, keyID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return nil, fmt.Errorf("failed to %s data key with KMS key %q: %s: %s",
+			op, keyID, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var out map[string][]byte // base64 in JSON
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KMS response: %w", err)
+	}
+
+	if len(out[outField]) == 0 {
+		return nil, fmt.Errorf("invalid KMS response: missing %s", outField)
+	}
+
+	return out[outField], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func sealedSize(size int64) int64 { // stored size of plaintext, every chunk carries a tag
+	if size < 0 {
+		return -1
+	}
+
+	chunks := max(1, (size+encryptionChunkSize-1)/encryptionChunkSize)
+
+	return size + chunks*encryptionOverhead
+}
+
+func openedSize(stored int64) int64 {
+	const sealedChunk = encryptionChunkSize + encryptionOverhead
+
+	chunks := max(1, (stored+sealedChunk-1)/sealedChunk)
+
+	return max(0, stored-chunks*encryptionOverhead)
+}
+
+func sealedRange(size, offset, length int64) (int64, int64) { // stored bytes holding plaintext range, as offset and length
+	const sealedChunk = encryptionChunkSize + encryptionOverhead
+
+	first := offset / encryptionChunkSize
+	last := max(first, (offset+length-1)/encryptionChunkSize)
+
+	start := first * sealedChunk
+	end := min((last+1)*sealedChunk, sealedSize(size))
+
+	return start, end - start
+}
+
+func chunkNonce(aead cipher.AEAD, index int64) []byte { // data key is unique per object, so counter nonces never repeat
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+
+	return nonce
+}
+
+func chunkAAD(final bool) []byte { // truncation at chunk boundary is detected
+	if final {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+type sealer struct {
+	src  *bufio.Reader
+	aead cipher.AEAD
+
+	index int64
+	buf   []byte
+	out   []byte
+	done  bool
+}
+
+func sealing(body io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealer{
+		src:  bufio.NewReaderSize(body, encryptionChunkSize),
+		aead: aead,
+		buf:  make([]byte, encryptionChunkSize+encryptionOverhead),
+	}, nil
+}
+
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(s.src, s.buf[:encryptionChunkSize])
+
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			s.done = true
+		case err != nil:
+			return 0, err
+		default:
+			_, err = s.src.Peek(1) // last full chunk must be sealed as final
+			if err == io.EOF {
+				s.done = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		s.out = s.aead.Seal(s.buf[:0], chunkNonce(s.aead, s.index), s.buf[:n], chunkAAD(s.done))
+		s.index++
+	}
+
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+
+	return n, nil
+}
+
+type opener struct {
+	body io.ReadCloser
+	aead cipher.AEAD
+
+	size  int64 // plaintext size, decides which chunk is final
+	index int64
+
+	buf       []byte
+	plain     []byte
+	skip      int64
+	remaining int64
+}
+
+func opening(body io.ReadCloser, dataKey []byte, size, offset, length int64) (io.ReadCloser, error) { // body starts at sealedRange of offset
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		body.Close()
+
+		return nil, err
+	}
+
+	return &opener{
+		body:      body,
+		aead:      aead,
+		size:      size,
+		index:     offset / encryptionChunkSize,
+		buf:       make([]byte, encryptionChunkSize+encryptionOverhead),
+		skip:      offset % encryptionChunkSize,
+		remaining: length,
+	}, nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	if o.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if len(o.plain) == 0 {
+		n := min(encryptionChunkSize, o.size-o.index*encryptionChunkSize)
+		if n < 0 {
+			return 0, ErrDecryptionFailed
+		}
+
+		sealed := o.buf[:n+encryptionOverhead]
+
+		_, err := io.ReadFull(o.body, sealed)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		final := (o.index+1)*encryptionChunkSize >= o.size
+
+		plain, err := o.aead.Open(sealed[:0], chunkNonce(o.aead, o.index), sealed, chunkAAD(final))
+		if err != nil {
+			return 0, fmt.Errorf("chunk %d: %w", o.index, ErrDecryptionFailed)
+		}
+
+		o.index++
+		o.plain = plain[o.skip:]
+		o.skip = 0
+	}
+
+	n := copy(p[:min(int64(len(p)), o.remaining)], o.plain)
+	o.plain = o.plain[n:]
+	o.remaining -= int64(n)
+
+	return n, nil
+}
+
+func (o *opener) Close() error {
+	return o.body.Close()
+}
+
+func (s *Store) seal(ctx context.Context, body io.Reader, metadata map[string]string) (io.Reader, error) { // wrapped data key is added to metadata
+	env, dataKey, err := s.keys.newEnvelope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	env.metadata(metadata)
+
+	return sealing(body, dataKey)
+}
+
+func (s *Store) open(ctx context.Context, env *envelope, body io.ReadCloser, size, offset, length int64) (io.ReadCloser, error) {
+	if env.scheme != encryptionScheme {
+		body.Close()
+
+		return nil, fmt.Errorf("unsupported encryption scheme %q", env.scheme)
+	}
+
+	dataKey, err := s.keys.unwrap(ctx, env)
+	if err != nil {
+		body.Close()
+
+		return nil, err
+	}
+
+	return opening(body, dataKey, size, offset, length)
+}
+
+func (s *Store) sealPart(ctx context.Context, namespace string, body io.Reader, size int64) (io.Reader, int64, map[string]string, error) { // metadata is nil when namespace is not encrypted
+	if !s.namespaces.For(namespace).encrypted() {
+		return body, size, nil, nil
+	}
+
+	meta := make(map[string]string)
+
+	sealed, err := s.seal(ctx, body, meta)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to encrypt part: %w", err)
+	}
+
+	return sealed, sealedSize(size), meta, nil
+}
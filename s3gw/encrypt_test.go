@@ -0,0 +1,184 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func newTestKeyManager(t *testing.T, ids ...string) *KeyManager {
+	t.Helper()
+
+	km := &KeyManager{
+		current: ids[0],
+		keys:    make(map[string]cipher.AEAD),
+	}
+
+	for _, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatalf("Failed to generate master key: %v", err)
+		}
+
+		aead, err := newGCM(key)
+		if err != nil {
+			t.Fatalf("Failed to create cipher: %v", err)
+		}
+
+		km.keys[id] = aead
+	}
+
+	return km
+}
+
+func sealTestBody(t *testing.T, body, dataKey []byte) []byte {
+	t.Helper()
+
+	r, err := sealing(bytes.NewReader(body), dataKey)
+	if err != nil {
+		t.Fatalf("Failed to create sealer: %v", err)
+	}
+
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to seal body: %v", err)
+	}
+
+	return sealed
+}
+
+func TestSealedRanges(t *testing.T) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatalf("Failed to generate data key: %v", err)
+	}
+
+	for _, size := range []int64{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 100} {
+		body := make([]byte, size)
+		if _, err := rand.Read(body); err != nil {
+			t.Fatalf("Failed to generate body: %v", err)
+		}
+
+		sealed := sealTestBody(t, body, dataKey)
+
+		if int64(len(sealed)) != sealedSize(size) {
+			t.Fatalf("Expected sealed size %d for %d bytes, got %d", sealedSize(size), size, len(sealed))
+		}
+
+		if got := openedSize(int64(len(sealed))); got != size {
+			t.Fatalf("Expected opened size %d, got %d", size, got)
+		}
+
+		for _, r := range [][2]int64{{0, size}, {0, 1}, {size / 2, size - size/2}, {encryptionChunkSize - 1, 2}, {size - 1, 1}} {
+			offset, length := r[0], r[1]
+			if offset < 0 || length < 0 || offset+length > size || size > 0 && length == 0 {
+				continue
+			}
+
+			start, n := sealedRange(size, offset, length)
+
+			rc, err := opening(io.NopCloser(bytes.NewReader(sealed[start:start+n])), dataKey, size, offset, length)
+			if err != nil {
+				t.Fatalf("Failed to open range %d+%d of %d bytes: %v", offset, length, size, err)
+			}
+
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("Failed to read range %d+%d of %d bytes: %v", offset, length, size, err)
+			}
+
+			if !bytes.Equal(got, body[offset:offset+length]) {
+				t.Errorf("Range %d+%d of %d bytes does not match plaintext", offset, length, size)
+			}
+		}
+	}
+}
+
+func TestSealedTampering(t *testing.T) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatalf("Failed to generate data key: %v", err)
+	}
+
+	const sealedChunk = encryptionChunkSize + encryptionOverhead
+
+	size := int64(2*encryptionChunkSize + 10)
+	sealed := sealTestBody(t, make([]byte, size), dataKey)
+
+	flipped := bytes.Clone(sealed)
+	flipped[10] ^= 1
+
+	reordered := append(bytes.Clone(sealed[sealedChunk:2*sealedChunk]), sealed[:sealedChunk]...)
+
+	for name, tc := range map[string]struct {
+		stored []byte
+		size   int64
+	}{
+		"flipped bit":          {flipped, size},
+		"truncated at chunk":   {sealed[:2*sealedChunk], 2 * encryptionChunkSize},
+		"reordered chunks":     {reordered, 2 * encryptionChunkSize},
+		"wrong final position": {sealed, size + 1},
+	} {
+		rc, err := opening(io.NopCloser(bytes.NewReader(tc.stored)), dataKey, tc.size, 0, tc.size)
+		if err != nil {
+			t.Fatalf("Failed to create opener: %v", err)
+		}
+
+		_, err = io.ReadAll(rc)
+		if err == nil {
+			t.Errorf("Expected %s to fail authentication", name)
+		} else if !errors.Is(err, ErrDecryptionFailed) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Unexpected error for %s: %v", name, err)
+		}
+	}
+}
+
+func TestKeyRewrap(t *testing.T) {
+	ctx := context.Background()
+
+	old := newTestKeyManager(t, "k1")
+
+	env, dataKey, err := old.newEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+
+	rotated := newTestKeyManager(t, "k2")
+	rotated.keys["k1"] = old.keys["k1"]
+
+	rewrapped, err := rotated.rewrap(ctx, env)
+	if err != nil {
+		t.Fatalf("Failed to rewrap data key: %v", err)
+	}
+
+	if rewrapped.keyID != "k2" || rewrapped.dataKey == env.dataKey {
+		t.Fatalf("Expected data key wrapped by %q, got %q", "k2", rewrapped.keyID)
+	}
+
+	delete(rotated.keys, "k1")
+
+	got, err := rotated.unwrap(ctx, rewrapped)
+	if err != nil {
+		t.Fatalf("Failed to unwrap rewrapped data key: %v", err)
+	}
+
+	if !bytes.Equal(got, dataKey) {
+		t.Errorf("Rewrapped data key differs from original")
+	}
+
+	if _, err = rotated.unwrap(ctx, env); !errors.Is(err, errUnknownMasterKey) {
+		t.Errorf("Expected unknown master key error, got %v", err)
+	}
+
+	tampered := *rewrapped
+	tampered.keyID = "k1"
+	rotated.keys["k1"] = rotated.keys["k2"]
+
+	if _, err = rotated.unwrap(ctx, &tampered); err == nil {
+		t.Errorf("Expected key ID to be authenticated")
+	}
+}
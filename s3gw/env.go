@@ -3,6 +3,7 @@ package s3gw
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 func MustGetIntFromEnv(key string) int {
@@ -32,3 +33,17 @@ func MustGetFloat64FromEnv(key string) float64 {
 
 	return n
 }
+
+func MustGetDurationFromEnv(key string) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
@@ -0,0 +1,799 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+const (
+	ErasureRepairIntervalEnvKey = "ERASURE_REPAIR_INTERVAL"
+)
+
+const erasureMaxBlockSize = 1 << 20 // per shard bytes of one stripe
+
+var (
+	ErrInsufficientShards = errors.New("not enough shards available")
+)
+
+type ErasureInfo struct {
+	DataShards   int            `json:"dataShards"`
+	ParityShards int            `json:"parityShards"`
+	BlockSize    int64          `json:"blockSize"`
+	Shards       []ErasureShard `json:"shards"`
+}
+
+type ErasureShard struct {
+	Backend string `json:"backend"` // shards stay where they were written, ring may change later
+	Key     string `json:"key"`
+	Missing bool   `json:"missing,omitempty"` // failed on write, rebuilt by repair job
+}
+
+func erasureShardKey(writeID string, index int) string {
+	return fmt.Sprintf("shards/%s/%03d", writeID, index)
+}
+
+func erasureHeadCopyKey(bucketName, id string) string { // manifest copies keep object readable while primary is down
+	return "heads/" + bucketName + "/" + id
+}
+
+func (e *ErasureInfo) stripeSize() int64 {
+	return int64(e.DataShards) * e.BlockSize
+}
+
+func (e *ErasureInfo) shardSize(size int64) int64 {
+	stripes := (size + e.stripeSize() - 1) / e.stripeSize()
+
+	return stripes * e.BlockSize
+}
+
+func (s *Store) putErasure(ctx context.Context, client *minio.Client, bucketName, namespace, id string, body io.Reader, size int64, opts PutOptions, nc NamespaceConfig) (minio.UploadInfo, *Manifest, error) {
+	k, n := nc.DataShards, nc.DataShards+nc.ParityShards
+
+	members, err := s.backends.LocateN(RingKey(namespace, id), n)
+	if err != nil {
+		return minio.UploadInfo{}, nil, fmt.Errorf("failed to place %d shards on distinct backends: %w", n, err)
+	}
+
+	enc, err := reedsolomon.New(k, nc.ParityShards)
+	if err != nil {
+		return minio.UploadInfo{}, nil, err
+	}
+
+	b := make([]byte, 16)
+
+	_, err = rand.Read(b)
+	if err != nil {
+		return minio.UploadInfo{}, nil, err
+	}
+
+	writeID := hex.EncodeToString(b)
+
+	e := &ErasureInfo{
+		DataShards:   k,
+		ParityShards: nc.ParityShards,
+		BlockSize:    erasureMaxBlockSize,
+		Shards:       make([]ErasureShard, n),
+	}
+
+	if size >= 0 { // small objects must not be padded to full block
+		e.BlockSize = max(1, min(erasureMaxBlockSize, (size+int64(k)-1)/int64(k)))
+	}
+
+	shardSize := int64(-1)
+	if size >= 0 {
+		shardSize = e.shardSize(size)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		writers = make([]*io.PipeWriter, n)
+		errs    = make([]error, n)
+	)
+
+	for i, bDef := range members {
+		e.Shards[i] = ErasureShard{
+			Backend: bDef.Name,
+			Key:     erasureShardKey(writeID, i),
+		}
+
+		pr, pw := io.Pipe()
+		writers[i] = pw
+
+		wg.Add(1)
+
+		go func(i int, bDef BackendDef) {
+			defer wg.Done()
+
+			_, errs[i] = s.putInternalAt(ctx, bDef, e.Shards[i].Key, pr, shardSize, nil)
+			pr.CloseWithError(errs[i]) // unblocks writer of failed shard
+		}(i, bDef)
+	}
+
+	abort := func(err error) (minio.UploadInfo, *Manifest, error) {
+		for _, pw := range writers {
+			pw.CloseWithError(err)
+		}
+
+		wg.Wait()
+
+		s.releaseManifest(ctx, &Manifest{Erasure: e})
+
+		return minio.UploadInfo{}, nil, err
+	}
+
+	var (
+		total int64
+		h     = md5.New()
+		buf   = make([]byte, e.stripeSize())
+		alive = n
+	)
+
+	shards := make([][]byte, n)
+	for i := k; i < n; i++ {
+		shards[i] = make([]byte, e.BlockSize)
+	}
+
+	for {
+		nr, err := io.ReadFull(body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+
+		if nr == 0 {
+			break
+		}
+
+		clear(buf[nr:]) // last stripe is zero padded
+		h.Write(buf[:nr])
+		total += int64(nr)
+
+		for i := 0; i < k; i++ {
+			shards[i] = buf[int64(i)*e.BlockSize : int64(i+1)*e.BlockSize]
+		}
+
+		err = enc.Encode(shards)
+		if err != nil {
+			return abort(err)
+		}
+
+		for i, pw := range writers {
+			if e.Shards[i].Missing {
+				continue
+			}
+
+			_, err = pw.Write(shards[i])
+			if err != nil {
+				e.Shards[i].Missing = true
+				alive--
+			}
+		}
+
+		if alive < k+1 {
+			return abort(fmt.Errorf("only %d of %d shards stored: %w", alive, n, ErrInsufficientShards))
+		}
+
+		if nr < len(buf) {
+			break
+		}
+	}
+
+	if size >= 0 && total != size {
+		return abort(io.ErrUnexpectedEOF)
+	}
+
+	for _, pw := range writers {
+		pw.Close()
+	}
+
+	wg.Wait()
+
+	alive = 0
+
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("Shard %d of object %q not stored on %q, left for repair: %v", i, id, e.Shards[i].Backend, err)
+
+			e.Shards[i].Missing = true
+
+			continue
+		}
+
+		alive++
+	}
+
+	if alive < k+1 { // write succeeds with one shard of redundancy
+		return abort(fmt.Errorf("only %d of %d shards stored: %w", alive, n, ErrInsufficientShards))
+	}
+
+	m := &Manifest{
+		Size:    total,
+		ETag:    hex.EncodeToString(h.Sum(nil)),
+		Erasure: e,
+	}
+
+	info, err := s.putManifest(ctx, client, bucketName, id, m, opts)
+	if err != nil {
+		s.releaseManifest(ctx, m)
+
+		return minio.UploadInfo{}, nil, err
+	}
+
+	s.putErasureHeadCopies(ctx, namespace, id, m, opts, members)
+
+	return info, m, nil
+}
+
+func (s *Store) putErasureHeadCopies(ctx context.Context, namespace, id string, m *Manifest, opts PutOptions, members []BackendDef) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+
+	bucketName := s.BucketName(namespace)
+	_, primary := s.backends.Locate(RingKey(namespace, id))
+
+	copies := 0
+
+	for _, bDef := range members {
+		if copies == m.Erasure.ParityShards {
+			break
+		}
+
+		if bDef.Name == primary {
+			continue
+		}
+
+		_, err = s.putInternalAt(ctx, bDef, erasureHeadCopyKey(bucketName, id), bytes.NewReader(data), int64(len(data)),
+			manifestMetadata(m, opts))
+		if err != nil {
+			log.Printf("Failed to store manifest copy of object %q on %q: %v", id, bDef.Name, err)
+
+			continue
+		}
+
+		copies++
+	}
+}
+
+func (s *Store) removeErasureHeadCopies(ctx context.Context, namespace, id string, m *Manifest) {
+	if m == nil || m.Erasure == nil {
+		return
+	}
+
+	members, err := s.backends.LocateN(RingKey(namespace, id), len(m.Erasure.Shards))
+	if err != nil {
+		members = s.backends.GetMembers()
+	}
+
+	for _, bDef := range members {
+		err = s.removeInternalAt(ctx, bDef, erasureHeadCopyKey(s.BucketName(namespace), id))
+		if err != nil {
+			log.Printf("Failed to remove manifest copy of object %q from %q: %v", id, bDef.Name, err)
+		}
+	}
+}
+
+func (s *Store) statErasureHeadCopy(ctx context.Context, namespace, id string) (ObjectInfo, error) {
+	nc := s.namespaces.For(namespace)
+
+	members, err := s.backends.LocateN(RingKey(namespace, id), nc.DataShards+nc.ParityShards)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	for _, bDef := range members {
+		info, err := s.statInternalAt(ctx, bDef, erasureHeadCopyKey(s.BucketName(namespace), id))
+		if err != nil {
+			continue
+		}
+
+		out := objectInfoFromMinio(info, bDef.Name)
+		out.Key = id
+		out.headCopy = true
+
+		return out, nil
+	}
+
+	return ObjectInfo{}, fmt.Errorf("object %q: %w", id, ErrObjectNotFound)
+}
+
+func (s *Store) readErasureHeadCopy(ctx context.Context, namespace, id string, info ObjectInfo) (*Manifest, error) {
+	bDef, ok := s.backends.Get(info.Backend)
+	if !ok {
+		return nil, ErrNoBackend
+	}
+
+	rc, err := s.getInternalAt(ctx, bDef, erasureHeadCopyKey(s.BucketName(namespace), id), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var m Manifest
+
+	err = json.NewDecoder(rc).Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest copy of object %q: %w", id, err)
+	}
+
+	return &m, nil
+}
+
+type stripeReader struct { // reads stripes from any k healthy shards
+	ctx   context.Context
+	store *Store
+	e     *ErasureInfo
+
+	stripe, last int64
+	skip         []bool // shards excluded from reading
+	readers      []io.ReadCloser
+}
+
+func (s *Store) newStripeReader(ctx context.Context, e *ErasureInfo, first, last int64, skip []bool) *stripeReader {
+	if skip == nil {
+		skip = make([]bool, len(e.Shards))
+	}
+
+	for i, shard := range e.Shards {
+		skip[i] = skip[i] || shard.Missing
+	}
+
+	return &stripeReader{
+		ctx:     ctx,
+		store:   s,
+		e:       e,
+		stripe:  first,
+		last:    last,
+		skip:    skip,
+		readers: make([]io.ReadCloser, len(e.Shards)),
+	}
+}
+
+func (r *stripeReader) next() ([][]byte, error) { // nil blocks are shards that were not read
+	if r.stripe > r.last {
+		return nil, io.EOF
+	}
+
+	blocks := make([][]byte, len(r.e.Shards))
+	got := 0
+
+	for i, shard := range r.e.Shards {
+		if got == r.e.DataShards || r.skip[i] { // unused readers would lose stripe alignment
+			r.closeShard(i)
+
+			continue
+		}
+
+		if r.readers[i] == nil {
+			bDef, ok := r.store.backends.Get(shard.Backend)
+			if !ok {
+				r.skip[i] = true
+
+				continue
+			}
+
+			rc, err := r.store.getInternalAt(r.ctx, bDef, shard.Key,
+				r.stripe*r.e.BlockSize, (r.last-r.stripe+1)*r.e.BlockSize)
+			if err != nil {
+				r.skip[i] = true
+
+				continue
+			}
+
+			r.readers[i] = rc
+		}
+
+		block := make([]byte, r.e.BlockSize)
+
+		_, err := io.ReadFull(r.readers[i], block)
+		if err != nil {
+			log.Printf("Failed to read shard %q from %q, reconstructing: %v", shard.Key, shard.Backend, err)
+
+			r.closeShard(i)
+			r.skip[i] = true
+
+			continue
+		}
+
+		blocks[i] = block
+		got++
+	}
+
+	if got < r.e.DataShards {
+		return nil, fmt.Errorf("stripe %d has %d of %d needed shards: %w", r.stripe, got, r.e.DataShards, ErrInsufficientShards)
+	}
+
+	r.stripe++
+
+	return blocks, nil
+}
+
+func (r *stripeReader) closeShard(i int) {
+	if r.readers[i] != nil {
+		r.readers[i].Close()
+		r.readers[i] = nil
+	}
+}
+
+func (r *stripeReader) Close() error {
+	for i := range r.readers {
+		r.closeShard(i)
+	}
+
+	return nil
+}
+
+type erasureReader struct {
+	enc     reedsolomon.Encoder
+	stripes *stripeReader
+
+	skip      int64 // bytes of first stripe before range
+	remaining int64
+	cur       []byte
+}
+
+func (s *Store) newErasureReader(ctx context.Context, m *Manifest, offset, length int64) (*erasureReader, error) {
+	e := m.Erasure
+
+	enc, err := reedsolomon.New(e.DataShards, e.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	first := offset / e.stripeSize()
+	last := first - 1 // empty range reads no stripes
+
+	if length > 0 {
+		last = (offset + length - 1) / e.stripeSize()
+	}
+
+	return &erasureReader{
+		enc:       enc,
+		stripes:   s.newStripeReader(ctx, e, first, last, nil),
+		skip:      offset - first*e.stripeSize(),
+		remaining: length,
+	}, nil
+}
+
+func (r *erasureReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if len(r.cur) == 0 {
+		blocks, err := r.stripes.next()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		err = r.enc.ReconstructData(blocks)
+		if err != nil {
+			return 0, err
+		}
+
+		r.cur = bytes.Join(blocks[:r.stripes.e.DataShards], nil)[r.skip:]
+		r.skip = 0
+	}
+
+	if int64(len(r.cur)) > r.remaining {
+		r.cur = r.cur[:r.remaining]
+	}
+
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	r.remaining -= int64(n)
+
+	return n, nil
+}
+
+func (r *erasureReader) Close() error {
+	return r.stripes.Close()
+}
+
+func RunErasureRepair(ctx context.Context, store *Store) {
+	interval := MustGetDurationFromEnv(ErasureRepairIntervalEnvKey)
+
+	if interval <= 0 {
+		log.Printf("Erasure coded shards repair is disabled")
+
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		repaired, err := store.repairErasureShards(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Erasure coded shards repair failed: %v", err)
+		}
+
+		if repaired > 0 {
+			log.Printf("Repaired %d erasure coded objects", repaired)
+		}
+	}
+}
+
+func (s *Store) repairErasureShards(ctx context.Context) (int, error) {
+	namespaces, err := s.ListNamespaces(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+
+	for _, ns := range namespaces {
+		if s.namespaces.For(ns.Name).Storage != StorageErasure {
+			continue
+		}
+
+		objects, _, err := s.ListObjects(ctx, ns.Name, "", "", 0)
+		if err != nil {
+			return repaired, err
+		}
+
+		for _, object := range objects {
+			if ctx.Err() != nil {
+				return repaired, ctx.Err()
+			}
+
+			ok, err := s.repairErasureObject(ctx, ns.Name, object.Key)
+			if err != nil {
+				log.Printf("Failed to repair object %q in namespace %q: %v", object.Key, ns.Name, err)
+
+				continue
+			}
+
+			if ok {
+				repaired++
+			}
+		}
+	}
+
+	return repaired, nil
+}
+
+func (s *Store) repairErasureObject(ctx context.Context, namespace, id string) (bool, error) {
+	object, err := s.statObject(ctx, namespace, id, GetOptions{}) // listings may lack metadata, head ETag guards rewrite below
+	if err != nil || object.layout != layoutManifest {
+		return false, err
+	}
+
+	client, _, err := s.locate(namespace, id)
+	if err != nil {
+		return false, err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	m, err := s.readManifest(ctx, client, bucketName, id, "")
+	if err != nil || m.Erasure == nil {
+		return false, err
+	}
+
+	e := m.Erasure
+	damaged := make([]bool, len(e.Shards))
+	used := make(map[string]bool, len(e.Shards))
+	count := 0
+
+	for i, shard := range e.Shards {
+		bDef, ok := s.backends.Get(shard.Backend)
+		if ok && !shard.Missing {
+			_, err = s.statInternalAt(ctx, bDef, shard.Key)
+		}
+
+		if !ok || shard.Missing || err != nil {
+			damaged[i] = true
+			count++
+
+			continue
+		}
+
+		used[shard.Backend] = true
+	}
+
+	if count == 0 {
+		return false, nil
+	}
+
+	targets := make([]BackendDef, len(e.Shards))
+
+	members, err := s.backends.LocateN(RingKey(namespace, id), len(s.backends.GetMembers()))
+	if err != nil {
+		return false, err
+	}
+
+	for i := range e.Shards {
+		if !damaged[i] {
+			continue
+		}
+
+		for _, bDef := range members { // prefer closest member that holds no other shard of object
+			if !used[bDef.Name] {
+				targets[i] = bDef
+				used[bDef.Name] = true
+
+				break
+			}
+		}
+
+		if targets[i].MinioClient == nil {
+			return false, fmt.Errorf("no backend left for shard %d", i)
+		}
+	}
+
+	err = s.rebuildShards(ctx, m, damaged, targets)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range e.Shards {
+		if damaged[i] {
+			e.Shards[i].Backend = targets[i].Name
+			e.Shards[i].Missing = false
+		}
+	}
+
+	unlock := s.lockObject(namespace, id) // PUT releasing old shards must not be overwritten by old manifest
+	defer unlock()
+
+	var t *tags.Tags
+
+	current, err := client.StatObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	if err == nil && current.ETag == object.headETag {
+		t, err = client.GetObjectTagging(ctx, bucketName, id, minio.GetObjectTaggingOptions{})
+	}
+
+	if err != nil || current.ETag != object.headETag { // object was overwritten during repair
+		s.releaseManifest(ctx, &Manifest{Erasure: &ErasureInfo{Shards: rebuiltShards(e, damaged)}})
+
+		return false, err
+	}
+
+	opts := PutOptions{
+		ContentType:  object.ContentType,
+		UserMetadata: withExpires(object.UserMetadata, object.Expires),
+		Checksums:    object.Checksums,
+		Expires:      object.Expires,
+	}
+
+	_, err = s.putManifest(ctx, client, bucketName, id, m, opts)
+	if err != nil {
+		return false, err
+	}
+
+	if t.Count() > 0 { // head object is replaced, tags are kept with it
+		err = client.PutObjectTagging(ctx, bucketName, id, t, minio.PutObjectTaggingOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to restore tags of object %q: %w", id, err)
+		}
+	}
+
+	s.putErasureHeadCopies(ctx, namespace, id, m, opts, members)
+
+	log.Printf("Rebuilt %d shards of object %q in namespace %q", count, id, namespace)
+
+	return true, nil
+}
+
+func rebuiltShards(e *ErasureInfo, damaged []bool) []ErasureShard {
+	out := make([]ErasureShard, 0)
+
+	for i, shard := range e.Shards {
+		if damaged[i] {
+			out = append(out, shard)
+		}
+	}
+
+	return out
+}
+
+func (s *Store) rebuildShards(ctx context.Context, m *Manifest, damaged []bool, targets []BackendDef) error {
+	e := m.Erasure
+
+	enc, err := reedsolomon.New(e.DataShards, e.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	stripes := e.shardSize(m.Size) / e.BlockSize
+	r := s.newStripeReader(ctx, e, 0, stripes-1, append([]bool(nil), damaged...))
+	defer r.Close()
+
+	var (
+		wg      sync.WaitGroup
+		writers = make([]*io.PipeWriter, len(e.Shards))
+		errs    = make([]error, len(e.Shards))
+	)
+
+	for i := range e.Shards {
+		if !damaged[i] {
+			continue
+		}
+
+		pr, pw := io.Pipe()
+		writers[i] = pw
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			_, errs[i] = s.putInternalAt(ctx, targets[i], e.Shards[i].Key, pr, e.shardSize(m.Size), nil)
+			pr.CloseWithError(errs[i])
+		}(i)
+	}
+
+	var rerr error
+
+	for rerr == nil {
+		blocks, err := r.next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			rerr = err
+
+			break
+		}
+
+		err = enc.Reconstruct(blocks)
+		if err != nil {
+			rerr = err
+
+			break
+		}
+
+		for i, pw := range writers {
+			if pw == nil {
+				continue
+			}
+
+			_, err = pw.Write(blocks[i])
+			if err != nil {
+				rerr = err
+
+				break
+			}
+		}
+	}
+
+	for _, pw := range writers {
+		if pw != nil {
+			pw.CloseWithError(rerr)
+		}
+	}
+
+	wg.Wait()
+
+	if rerr != nil {
+		return rerr
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+const testErasureNamespace = "archive"
+
+func removeShards(t *testing.T, s *Store, id string, indexes ...int) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	client, _, err := s.locate(testErasureNamespace, id)
+	if err != nil {
+		t.Fatalf("Failed to locate object %q: %v", id, err)
+	}
+
+	m, err := s.readManifest(ctx, client, s.BucketName(testErasureNamespace), id, "")
+	if err != nil || m.Erasure == nil {
+		t.Fatalf("Failed to read erasure manifest of %q: %v", id, err)
+	}
+
+	for _, i := range indexes {
+		shard := m.Erasure.Shards[i]
+
+		bDef, ok := s.backends.Get(shard.Backend)
+		if !ok {
+			t.Fatalf("Unknown backend %q of shard %d", shard.Backend, i)
+		}
+
+		err := s.removeInternalAt(ctx, bDef, shard.Key)
+		if err != nil {
+			t.Fatalf("Failed to remove shard %d: %v", i, err)
+		}
+	}
+}
+
+func readErasureObject(s *Store, id string, br *ByteRange) ([]byte, error) {
+	object, err := s.GetObject(context.Background(), testErasureNamespace, id, GetOptions{Range: br})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return io.ReadAll(object)
+}
+
+func TestErasureReconstruction(t *testing.T) {
+	s := newTestStore(t, newTestBackends(t, 5), map[string]NamespaceConfig{
+		testErasureNamespace: {Storage: StorageErasure, DataShards: 3, ParityShards: 2},
+	})
+
+	for _, size := range []int{1, 1000, 3*erasureMaxBlockSize + 123} { // last one spans two stripes
+		body := make([]byte, size)
+		if _, err := rand.Read(body); err != nil {
+			t.Fatalf("Failed to generate body: %v", err)
+		}
+
+		for _, missing := range [][]int{{}, {0}, {4}, {1, 3}, {0, 2}, {3, 4}} { // up to ParityShards of data or parity shards
+			id := fmt.Sprintf("obj-%d-%v", size, missing)
+
+			_, err := s.PutObject(context.Background(), testErasureNamespace, id, bytes.NewReader(body), int64(size), PutOptions{})
+			if err != nil {
+				t.Fatalf("Failed to put object of %d bytes: %v", size, err)
+			}
+
+			removeShards(t, s, id, missing...)
+
+			data, err := readErasureObject(s, id, nil)
+			if err != nil || !bytes.Equal(data, body) {
+				t.Errorf("Expected %d bytes with shards %v missing, got %d: %v", size, missing, len(data), err)
+			}
+
+			if size < 2 {
+				continue
+			}
+
+			br := &ByteRange{Start: int64(size / 2), End: int64(size - 2)}
+
+			data, err = readErasureObject(s, id, br)
+			if err != nil || !bytes.Equal(data, body[size/2:size-1]) {
+				t.Errorf("Expected range of %d bytes with shards %v missing, got %d: %v", size, missing, len(data), err)
+			}
+		}
+
+		id := fmt.Sprintf("obj-%d-lost", size)
+
+		_, err := s.PutObject(context.Background(), testErasureNamespace, id, bytes.NewReader(body), int64(size), PutOptions{})
+		if err != nil {
+			t.Fatalf("Failed to put object of %d bytes: %v", size, err)
+		}
+
+		removeShards(t, s, id, 0, 2, 4)
+
+		_, err = readErasureObject(s, id, nil)
+		if !errors.Is(err, ErrInsufficientShards) {
+			t.Errorf("Expected %v with 3 of 5 shards missing, got %v", ErrInsufficientShards, err)
+		}
+	}
+}
+
+func TestErasureRepairKeepsExpiryAndTags(t *testing.T) {
+	s := newTestStore(t, newTestBackends(t, 5), map[string]NamespaceConfig{
+		testErasureNamespace: {Storage: StorageErasure, DataShards: 3, ParityShards: 2},
+	})
+	ctx := context.Background()
+
+	body := bytes.Repeat([]byte("repair-"), 10000)
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	_, err := s.PutObject(ctx, testErasureNamespace, "obj", bytes.NewReader(body), int64(len(body)), PutOptions{Expires: expires})
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	err = s.PutObjectTags(ctx, testErasureNamespace, "obj", map[string]string{"team": "storage"})
+	if err != nil {
+		t.Fatalf("Failed to put tags: %v", err)
+	}
+
+	removeShards(t, s, "obj", 1)
+
+	repaired, err := s.repairErasureObject(ctx, testErasureNamespace, "obj")
+	if err != nil || !repaired {
+		t.Fatalf("Expected object to be repaired, got %v: %v", repaired, err)
+	}
+
+	removeShards(t, s, "obj", 0, 2) // rebuilt shard is needed to read object
+
+	data, err := readErasureObject(s, "obj", nil)
+	if err != nil || !bytes.Equal(data, body) {
+		t.Errorf("Expected body of repaired object, got %d bytes: %v", len(data), err)
+	}
+
+	info, err := s.StatObject(ctx, testErasureNamespace, "obj", GetOptions{})
+	if err != nil || !info.Expires.Equal(expires) {
+		t.Errorf("Expected repaired object to expire at %s, got %s: %v", expires, info.Expires, err)
+	}
+
+	tags, err := s.GetObjectTags(ctx, testErasureNamespace, "obj")
+	if err != nil || tags["team"] != "storage" {
+		t.Errorf("Expected tags of repaired object to be kept, got %v: %v", tags, err)
+	}
+}
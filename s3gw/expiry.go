@@ -0,0 +1,218 @@
+package s3gw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExpirySweepIntervalEnvKey = "EXPIRY_SWEEP_INTERVAL" // expired objects are hidden at once, sweeper removes them physically
+)
+
+const (
+	headerObjectExpires = "X-Object-Expires" // HTTP date or seconds from now
+	metaExpires         = "Gw-Expires"
+)
+
+var ErrInvalidExpires = errors.New("invalid X-Object-Expires, must be HTTP date or positive number of seconds")
+
+type LifecycleRule struct {
+	Prefix      string `json:"prefix"`
+	ExpireAfter string `json:"expireAfter"` // Go duration since last write
+}
+
+func ExpiresFromRequest(h http.Header) (time.Time, error) { // zero when object does not expire on its own
+	v := h.Get(headerObjectExpires)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if seconds <= 0 {
+			return time.Time{}, ErrInvalidExpires
+		}
+
+		return time.Now().Add(time.Duration(seconds) * time.Second).UTC(), nil
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return time.Time{}, ErrInvalidExpires
+	}
+
+	return t.UTC(), nil
+}
+
+func withExpires(userMetadata map[string]string, expires time.Time) map[string]string { // caller's map is left untouched
+	if expires.IsZero() {
+		return userMetadata
+	}
+
+	out := make(map[string]string, len(userMetadata)+1)
+	for k, v := range userMetadata {
+		out[k] = v
+	}
+
+	out[metaExpires] = expires.UTC().Format(time.RFC3339)
+
+	return out
+}
+
+func expiresFromMetadata(userMetadata map[string]string) time.Time {
+	t, err := time.Parse(time.RFC3339, metaValue(userMetadata, metaExpires))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+func writeExpiresHeader(w http.ResponseWriter, expires time.Time) {
+	if !expires.IsZero() {
+		w.Header().Set(headerObjectExpires, expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (nc NamespaceConfig) expireAfter(key string) time.Duration { // longest matching rule wins over namespace default
+	value, matched := nc.ExpireAfter, -1
+
+	for _, rule := range nc.Lifecycle {
+		if strings.HasPrefix(key, rule.Prefix) && len(rule.Prefix) > matched {
+			value, matched = rule.ExpireAfter, len(rule.Prefix)
+		}
+	}
+
+	d, _ := time.ParseDuration(value) // validated on load
+
+	return d
+}
+
+func (s *Store) applyExpiry(namespace string, info *ObjectInfo) bool { // fills rule based expiry, true when object is gone
+	if info.Expires.IsZero() {
+		if d := s.namespaces.For(namespace).expireAfter(info.Key); d > 0 {
+			info.Expires = info.LastModified.Add(d)
+		}
+	}
+
+	return !info.Expires.IsZero() && !time.Now().Before(info.Expires)
+}
+
+func (s *Store) removeExpired(ctx context.Context, namespace, id string) (bool, error) { // expiry is rechecked, object may have been rewritten since listing
+	defer s.lockObject(namespace, id)()
+
+	info, err := s.statObject(ctx, namespace, id, GetOptions{})
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+
+	if err != nil || !s.applyExpiry(namespace, &info) {
+		return false, err
+	}
+
+	_, err = s.removeObject(ctx, namespace, id)
+
+	return err == nil, err
+}
+
+func (s *Store) sweepExpired(ctx context.Context, namespace string) (int, error) {
+	removed, startAfter := 0, ""
+
+	for {
+		objects, truncated, err := s.listObjects(ctx, namespace, "", startAfter, s3MaxKeys)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, info := range objects {
+			if !s.applyExpiry(namespace, &info) {
+				continue
+			}
+
+			ok, err := s.removeExpired(ctx, namespace, info.Key)
+			if err != nil {
+				log.Printf("Failed to remove expired object %q of namespace %q: %v", info.Key, namespace, err)
+
+				continue
+			}
+
+			if ok {
+				removed++
+			}
+		}
+
+		if !truncated || len(objects) == 0 {
+			return removed, nil
+		}
+
+		startAfter = objects[len(objects)-1].Key
+	}
+}
+
+func RunExpirySweeper(ctx context.Context, store *Store) { // per-object expiry may be set in any namespace, so all are swept
+	interval := MustGetDurationFromEnv(ExpirySweepIntervalEnvKey)
+	if interval <= 0 {
+		log.Printf("Expired object sweeper is disabled")
+
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		namespaces, err := store.ListNamespaces(ctx)
+		if err != nil {
+			log.Printf("Expired object sweep failed: %v", err)
+
+			continue
+		}
+
+		for _, ns := range namespaces {
+			removed, err := store.sweepExpired(ctx, ns.Name)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Expired object sweep of namespace %q failed: %v", ns.Name, err)
+			}
+
+			if removed > 0 {
+				log.Printf("Removed %d expired objects from namespace %q", removed, ns.Name)
+			}
+		}
+	}
+}
+
+func validateLifecycle(nc NamespaceConfig) error {
+	durations := []string{nc.ExpireAfter}
+
+	for _, rule := range nc.Lifecycle {
+		if rule.Prefix == "" {
+			return fmt.Errorf("lifecycle rule needs a prefix, use expireAfter for whole namespace")
+		}
+
+		durations = append(durations, rule.ExpireAfter)
+	}
+
+	for i, v := range durations {
+		if i == 0 && v == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid expiration %q, must be positive duration", v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package s3gw
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaceExpireAfter(t *testing.T) {
+	nc := NamespaceConfig{
+		ExpireAfter: "720h",
+		Lifecycle: []LifecycleRule{
+			{Prefix: "tmp/", ExpireAfter: "1h"},
+			{Prefix: "tmp/keep/", ExpireAfter: "48h"},
+		},
+	}
+
+	err := nc.validate()
+	if err != nil {
+		t.Fatalf("Expected valid lifecycle, got %v", err)
+	}
+
+	for key, expected := range map[string]time.Duration{
+		"report.pdf":       720 * time.Hour,
+		"tmp/upload":       time.Hour,
+		"tmp/keep/archive": 48 * time.Hour,
+		"tmpfile":          720 * time.Hour,
+	} {
+		if d := nc.expireAfter(key); d != expected {
+			t.Errorf("Expected %q to expire after %v, got %v", key, expected, d)
+		}
+	}
+
+	if d := (NamespaceConfig{}).expireAfter("any"); d != 0 {
+		t.Errorf("Expected no expiry without rules, got %v", d)
+	}
+
+	for _, invalid := range []NamespaceConfig{
+		{ExpireAfter: "soon"},
+		{ExpireAfter: "-1h"},
+		{Lifecycle: []LifecycleRule{{Prefix: "", ExpireAfter: "1h"}}},
+		{Lifecycle: []LifecycleRule{{Prefix: "tmp/"}}},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
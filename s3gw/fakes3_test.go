@@ -0,0 +1,332 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/consistent"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type fakeS3Object struct {
+	data     []byte
+	header   http.Header // Content-Type and X-Amz-Meta-*
+	tagging  []byte      // Tagging XML as sent, replaced object has none
+	etag     string
+	modified time.Time
+}
+
+type fakeS3 struct { // in-memory backend, just enough S3 for store tests
+	mu      sync.Mutex
+	buckets map[string]map[string]*fakeS3Object
+}
+
+func newTestBackends(t *testing.T, n int) *Backends {
+	t.Helper()
+
+	b := &Backends{backends: make(map[string]*minio.Client), secure: true}
+	members := make([]consistent.Member, 0, n)
+
+	for i := 0; i < n; i++ {
+		srv := httptest.NewTLSServer(&fakeS3{buckets: make(map[string]map[string]*fakeS3Object)}) // TLS keeps minio client from chunk signing bodies
+		t.Cleanup(srv.Close)
+
+		client, err := minio.New(srv.Listener.Addr().String(), &minio.Options{
+			Creds:     credentials.NewStaticV4("test", "test-secret", ""),
+			Secure:    true,
+			Transport: srv.Client().Transport,
+			Region:    "us-east-1",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create S3 client: %v", err)
+		}
+
+		name := fmt.Sprintf("node%d", i+1)
+		b.backends[name] = client
+		members = append(members, Member(name))
+	}
+
+	b.ch = consistent.New(members, consistent.Config{PartitionCount: 71, ReplicationFactor: 20, Load: 1.25, Hasher: hasher{}})
+
+	return b
+}
+
+func newTestStore(t *testing.T, backends *Backends, namespaces map[string]NamespaceConfig) *Store {
+	t.Helper()
+
+	t.Setenv(S3InternalBucketNameEnvKey, "gateway-internal")
+	t.Setenv(S3NamespaceBucketPrefixEnvKey, "ns-")
+	t.Setenv(ChunkedStorageThresholdEnvKey, "33554432")
+	t.Setenv(ChunkSizeEnvKey, "8388608")
+	t.Setenv(ChunkPrefetchEnvKey, "4")
+	t.Setenv(ObjectCacheSizeEnvKey, "0")
+
+	return NewStore(backends, "objects", &NamespaceConfigs{Namespaces: namespaces}, nil, nil)
+}
+
+func writeFakeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource></Error>",
+			code, code, r.URL.Path)
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if key == "" {
+		f.serveBucket(w, r, bucketName)
+
+		return
+	}
+
+	bucket, ok := f.buckets[bucketName]
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+
+		return
+	}
+
+	if r.URL.Query().Has("tagging") {
+		f.serveTagging(w, r, bucket, key)
+
+		return
+	}
+
+	if len(r.URL.Query()) > 0 { // versions and multipart uploads are not emulated
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		f.putObject(w, r, bucket, key)
+	case http.MethodGet, http.MethodHead:
+		object, ok := bucket[key]
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+
+			return
+		}
+
+		for k, v := range object.header {
+			w.Header()[k] = v
+		}
+
+		w.Header().Set("ETag", strconv.Quote(object.etag))
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		data, status := object.data, http.StatusOK
+
+		if rng := r.Header.Get("Range"); rng != "" {
+			br, err := ParseByteRange(rng)
+			if err == nil {
+				var offset, length int64
+
+				offset, length, err = br.Resolve(int64(len(object.data)))
+				if err == nil {
+					data, status = object.data[offset:offset+length], http.StatusPartialContent
+					w.Header().Set("Content-Range", ContentRange(offset, length, int64(len(object.data))))
+				}
+			}
+
+			if err != nil {
+				writeFakeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+
+				return
+			}
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) serveTagging(w http.ResponseWriter, r *http.Request, bucket map[string]*fakeS3Object, key string) {
+	object, ok := bucket[key]
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/xml")
+
+		if object.tagging == nil {
+			fmt.Fprint(w, "<Tagging><TagSet></TagSet></Tagging>")
+
+			return
+		}
+
+		w.Write(object.tagging)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+
+			return
+		}
+
+		object.tagging = data
+	case http.MethodDelete:
+		object.tagging = nil
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) putObject(w http.ResponseWriter, r *http.Request, bucket map[string]*fakeS3Object, key string) {
+	object := &fakeS3Object{header: make(http.Header), modified: time.Now()}
+
+	meta := r.Header
+
+	if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+		src, err := url.PathUnescape(src)
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidArgument")
+
+			return
+		}
+
+		srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+
+		source, ok := f.buckets[srcBucket][srcKey]
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+
+			return
+		}
+
+		object.data = source.data
+
+		if r.Header.Get("X-Amz-Metadata-Directive") != "REPLACE" {
+			meta = source.header
+		}
+	} else {
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+
+			return
+		}
+
+		object.data = data
+	}
+
+	for k, v := range meta {
+		if k == "Content-Type" || strings.HasPrefix(k, "X-Amz-Meta-") {
+			object.header[k] = v
+		}
+	}
+
+	sum := md5.Sum(object.data)
+	object.etag = hex.EncodeToString(sum[:])
+	bucket[key] = object
+
+	w.Header().Set("ETag", strconv.Quote(object.etag))
+
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%q</ETag><LastModified>%s</LastModified></CopyObjectResult>",
+			object.etag, object.modified.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, ok := f.buckets[bucketName]
+
+	switch {
+	case r.Method == http.MethodPut:
+		if !ok {
+			f.buckets[bucketName] = make(map[string]*fakeS3Object)
+		}
+	case !ok:
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+	case r.Method == http.MethodHead:
+	case r.Method == http.MethodDelete:
+		delete(f.buckets, bucketName)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.listObjects(w, r, bucketName, bucket)
+	default:
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, r *http.Request, bucketName string, bucket map[string]*fakeS3Object) { // recursive listings only, without pagination
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucketName, Prefix: r.URL.Query().Get("prefix")}
+
+	for key, object := range bucket {
+		if strings.HasPrefix(key, result.Prefix) && key > r.URL.Query().Get("start-after") {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: object.modified.UTC().Format(time.RFC3339Nano),
+				ETag:         strconv.Quote(object.etag),
+				Size:         len(object.data),
+			})
+		}
+	}
+
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+
+	err := xml.NewEncoder(w).Encode(result)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -1,15 +1,12 @@
 package s3gw
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
-
-	"github.com/minio/minio-go/v7"
 )
 
 /*
@@ -25,238 +22,301 @@ func HandleNotFound(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func HandleObjectPut(w http.ResponseWriter, r *http.Request, backends *Backends, bucketName string) {
-	id := GetID(r, w)
-	if id == "" {
+func HandleObjectPut(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
 		http.Error(w,
-			"Invalid ID, must be alphanumeric and up to 32 characters",
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
 			http.StatusBadRequest,
 		)
 
 		return
 	}
 
-	backendClient, backendContainerID := backends.Locate(id)
-	if backendClient == nil {
+	id := GetID(r, w)
+	if id == "" {
 		http.Error(w,
-			"Failed to find S3 backend ID",
-			http.StatusInternalServerError,
+			InvalidIDMessage(),
+			http.StatusBadRequest,
 		)
 
 		return
 	}
 
 	ctx := r.Context()
-
-	err := EnsureBucketExists(ctx, backendClient, bucketName)
-	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to ensure S3 bucket %q existance on %q",
-				bucketName, backendContainerID),
-			http.StatusInternalServerError,
-		)
-
-		return
-	}
+	conditions := WriteConditionsFromRequest(r.Header)
 
 	if r.ContentLength == 0 {
-		err = backendClient.RemoveObject(ctx, bucketName, id,
-			minio.RemoveObjectOptions{
-				ForceDelete: true,
-			},
-		)
+		info, err := store.RemoveObject(ctx, namespace, id, conditions)
 		if err != nil {
-			http.Error(w,
-				fmt.Sprintf("Failed to remove object %q from %q: %v",
-					id, backendContainerID, err),
-				http.StatusInternalServerError,
-			)
+			writeObjectError(w, err)
 
 			return
 		}
 
 		w.WriteHeader(http.StatusNoContent)
-		log.Printf("Object %q deleted from %q", id, backendContainerID)
+		log.Printf("Object %q deleted from %q", id, info.Backend)
 
 		return
 	}
 
 	defer r.Body.Close()
 
-	_, err = backendClient.PutObject(ctx, bucketName, id, r.Body, r.ContentLength,
-		minio.PutObjectOptions{
-			ContentType: "application/octet-stream",
-		},
-	)
+	checksums, err := ChecksumsFromRequest(r.Header)
 	if err != nil {
 		http.Error(w,
-			fmt.Sprintf("Failed to upload object %q to %q: %v",
-				id, backendContainerID, err),
-			http.StatusInternalServerError,
+			CapitalizeErrorString(err),
+			http.StatusBadRequest,
 		)
 
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	log.Printf("Object %q uploaded to %q", id, backendContainerID)
-}
+	sse, err := SSECFromRequest(r.Header)
+	if err != nil {
+		writeObjectError(w, err)
 
-func HandleObjectGet(w http.ResponseWriter, r *http.Request, backends *Backends, bucketName string) {
-	id := GetID(r, w)
-	if id == "" {
+		return
+	}
+
+	expires, err := ExpiresFromRequest(r.Header)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	body := NewChecksumReader(r.Body, checksums, r.ContentLength)
+
+	info, err := store.PutObject(ctx, namespace, id, body, r.ContentLength,
+		PutOptions{Checksums: checksums, SSEC: sse, Conditions: conditions, Expires: expires})
+	if body.Err() != nil {
 		http.Error(w,
-			"Invalid ID, must be alphanumeric and up to 32 characters",
+			CapitalizeErrorString(body.Err()),
 			http.StatusBadRequest,
 		)
+
+		log.Printf("Object %q rejected: %v", id, body.Err())
+
+		return
+	}
+
+	if err != nil {
+		writeObjectError(w, err)
+
 		return
 	}
 
-	backendClient, backendContainerID := backends.Locate(id)
-	if backendClient == nil {
+	writeSSECHeaders(w, info.customerKeyMD5)
+	writeVersionHeader(w, info.VersionID)
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusCreated)
+	log.Printf("Object %q uploaded to %q", id, info.Backend)
+}
+
+func HandleObjectGet(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
 		http.Error(w,
-			"Failed to find S3 backend ID",
-			http.StatusInternalServerError,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
 		)
 
 		return
 	}
 
-	ctx := r.Context()
-
-	exists, err := backendClient.BucketExists(ctx, bucketName)
-	if err != nil {
+	id := GetID(r, w)
+	if id == "" {
 		http.Error(w,
-			fmt.Sprintf("Failed to check S3 bucket %q existance on %q",
-				bucketName, backendContainerID),
-			http.StatusInternalServerError,
+			InvalidIDMessage(),
+			http.StatusBadRequest,
 		)
 
 		return
 	}
 
-	if !exists {
+	br, err := ParseByteRange(r.Header.Get("Range"))
+	if err != nil {
 		http.Error(w,
-			fmt.Sprintf("Bucket %q not found on %q",
-				bucketName, backendContainerID),
-			http.StatusNotFound,
+			CapitalizeErrorString(err),
+			http.StatusRequestedRangeNotSatisfiable,
 		)
 
 		return
 	}
 
-	_, err = backendClient.StatObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	sse, err := SSECFromRequest(r.Header)
 	if err != nil {
-		switch minio.ToErrorResponse(err).Code {
-		case "NoSuchKey", "NotFoundObject":
-			http.Error(w,
-				fmt.Sprintf("Object %q not found on %q",
-					id, backendContainerID),
-				http.StatusNotFound,
-			)
-
-			log.Printf("Object %q not found on %q", id, backendContainerID)
-		case "AccessDenied":
-			http.Error(w,
-				fmt.Sprintf("%d - Forbidden",
-					http.StatusForbidden),
-				http.StatusForbidden,
-			)
-		default:
-			http.Error(w,
-				fmt.Sprintf("Internal server error: %v",
-					err),
-				http.StatusInternalServerError)
-		}
+		writeObjectError(w, err)
 
 		return
 	}
 
-	object, err := backendClient.GetObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	object, err := store.GetObject(r.Context(), namespace, id, GetOptions{
+		Range:          br,
+		AcceptEncoding: r.Header.Get("Accept-Encoding"),
+		SSEC:           sse,
+		VersionID:      r.URL.Query().Get("versionId"),
+	})
 	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Internal server error: %v",
-				err),
-			http.StatusInternalServerError)
+		writeObjectError(w, err)
+
+		if errors.Is(err, ErrObjectNotFound) {
+			log.Printf("Object %q not found", id)
+		}
 
 		return
 	}
 	defer object.Close()
 
 	w.Header().Set("Content-Type", "application/octet-stream")
+	writeObjectHeaders(w, object)
 
-	if _, err := io.Copy(w, object); err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to write object to response: %v",
-				err),
-			http.StatusInternalServerError)
+	if _, err := copyObjectVerified(w, object); err != nil {
+		abortObjectResponse(id, err)
 	}
 
-	log.Printf("Object %q fetched from %q", id, backendContainerID)
+	log.Printf("Object %q fetched from %q", id, object.Info.Backend)
 }
 
-func HandleObjectList(w http.ResponseWriter, r *http.Request, backends *Backends, bucketName string) {
-	backendDefs := backends.GetMembers()
-	if len(backendDefs) == 0 {
-		http.Error(w,
-			"Failed to list S3 backend IDs",
-			http.StatusInternalServerError,
-		)
+func abortObjectResponse(id string, err error) { // status is already sent, client must not take partial body for whole object
+	log.Printf("Failed to write object %q to response: %v", id, err)
 
+	if errors.Is(err, ErrCorruptedObject) { // body is complete, failure is reported in trailer
 		return
 	}
 
-	var wg sync.WaitGroup
-
-	ctx := r.Context()
-
-	w.Header().Set("Content-Type", "application/octet-stream")
+	panic(http.ErrAbortHandler)
+}
 
-	for _, bDef := range backendDefs {
-		wg.Add(1)
+func HandleObjectList(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		http.Error(w,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
+		)
 
-		worker(ctx, &wg, w, r, bDef, bucketName)
+		return
 	}
 
-	wg.Wait()
-	log.Printf("Fetched all keys")
-}
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
 
-func worker(ctx context.Context, wg *sync.WaitGroup, w http.ResponseWriter, r *http.Request, bDef BackendDef, bucketName string) {
-	defer wg.Done()
+	filter := TagFilterFromQuery(query["tag"])
 
-	exists, err := bDef.MinioClient.BucketExists(ctx, bucketName)
+	objects, _, err := store.ListObjects(r.Context(), namespace, prefix, "", 0)
 	if err != nil {
 		http.Error(w,
-			fmt.Sprintf("Failed to check S3 bucket %q existance on %q",
-				bucketName, bDef.Name),
+			CapitalizeErrorString(err),
 			http.StatusInternalServerError,
 		)
 
 		return
 	}
 
-	if !exists {
-		http.Error(w,
-			fmt.Sprintf("Bucket %q not found on %q",
-				bucketName, bDef.Name),
-			http.StatusNotFound,
-		)
+	listing := make([]string, 0, len(objects))
+	for _, object := range objects {
+		if filter.Matches(object.Tags) {
+			listing = append(listing, object.Key)
+		}
+	}
 
-		return
+	keys, commonPrefixes := GroupKeysByDelimiter(listing, prefix, delimiter)
+	entries := MergeKeyListings(keys, commonPrefixes)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(strings.Join(entries, " "))) // common prefixes are listed like keys, ending in delimiter
+
+	log.Printf("Fetched all keys")
+}
+
+func writeObjectHeaders(w http.ResponseWriter, object *ObjectReader) {
+	h := w.Header()
+
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Length", strconv.FormatInt(object.Length, 10))
+	h.Set("ETag", strconv.Quote(object.Info.ETag))
+	h.Set("Last-Modified", object.Info.LastModified.UTC().Format(http.TimeFormat))
+
+	writeChecksumHeaders(w, object.Info.Checksums)
+	announceChecksumTrailer(w, object)
+
+	if object.Info.compression != "" { // representation depends on Accept-Encoding
+		h.Set("Vary", "Accept-Encoding")
 	}
 
-	keys, err := ListObjectsInBucket(ctx, bDef.MinioClient, bucketName)
-	if err != nil {
-		http.Error(w,
-			fmt.Sprintf("Failed to list keys in S3 backend on %q", bDef.Name),
-			http.StatusInternalServerError,
-		)
+	if object.ContentEncoding != "" {
+		h.Set("Content-Encoding", object.ContentEncoding)
+	}
+
+	writeSSECHeaders(w, object.Info.customerKeyMD5)
+	writeVersionHeader(w, object.Info.VersionID)
+	writeExpiresHeader(w, object.Info.Expires)
+
+	if object.ContentEncoding != "" || object.Length == object.Info.Size && object.Offset == 0 {
+		w.WriteHeader(http.StatusOK)
 
 		return
 	}
 
-	w.Write([]byte(strings.Join(keys, " ")))
+	h.Set("Content-Range", ContentRange(object.Offset, object.Length, object.Info.Size))
+	w.WriteHeader(http.StatusPartialContent)
+}
 
-	log.Printf("Processing keys from %q", bDef.Name)
+func writeObjectError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrObjectNotFound), errors.Is(err, ErrBucketNotFound):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusNotFound,
+		)
+	case errors.Is(err, ErrNoSuchUpload):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusNotFound,
+		)
+	case errors.Is(err, ErrInvalidPart), errors.Is(err, ErrInvalidPartOrder):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusBadRequest,
+		)
+	case errors.Is(err, ErrAccessDenied):
+		http.Error(w,
+			fmt.Sprintf("%d - Forbidden",
+				http.StatusForbidden),
+			http.StatusForbidden,
+		)
+	case errors.Is(err, ErrInvalidRange):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusRequestedRangeNotSatisfiable,
+		)
+	case errors.Is(err, ErrInvalidSSEC), errors.Is(err, ErrSSECMismatch), errors.Is(err, ErrSSECInsecure):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusBadRequest,
+		)
+	case errors.Is(err, ErrSSECNotSupported):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusNotImplemented,
+		)
+	case errors.Is(err, ErrPreconditionFailed):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusPreconditionFailed,
+		)
+	case errors.Is(err, ErrVersioningDisabled), errors.Is(err, ErrInvalidExpires), errors.Is(err, ErrInvalidTags), errors.Is(err, ErrCopyToSelf),
+		errors.Is(err, errPayloadMismatch):
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusBadRequest,
+		)
+	default:
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusInternalServerError)
+	}
 }
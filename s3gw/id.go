@@ -1,19 +1,91 @@
 package s3gw
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
-var idRegExp *regexp.Regexp
+const (
+	ObjectKeyPolicyEnvKey = "OBJECT_KEY_POLICY"
+)
+
+const maxIDLength = 1024 // S3 object key limit
+
+type keyPolicy struct {
+	pattern     string
+	description string
+}
+
+var keyPolicyPresets = map[string]keyPolicy{
+	"strict": {
+		pattern:     `^[a-zA-Z0-9]{1,32}$`,
+		description: "alphanumeric and up to 32 characters",
+	},
+	"uuid": {
+		pattern:     `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`,
+		description: "a UUID",
+	},
+	"s3-safe": {
+		pattern:     `^[a-zA-Z0-9!_.*'()-]+(/[a-zA-Z0-9!_.*'()-]+)*$`,
+		description: "S3 safe characters in slash separated segments and up to 1024 characters",
+	},
+}
+
+var (
+	idRegExp      *regexp.Regexp
+	idDescription string
+)
 
 func init() {
-	idRegExp = regexp.MustCompile(`^[a-zA-Z0-9]{1,32}$`)
+	idRegExp = regexp.MustCompile(keyPolicyPresets["strict"].pattern)
+	idDescription = keyPolicyPresets["strict"].description
+}
+
+func ConfigureKeyPolicy() error { // preset name or custom regular expression, must be called before serving requests
+	policy := os.Getenv(ObjectKeyPolicyEnvKey)
+	if policy == "" {
+		return nil
+	}
+
+	if preset, ok := keyPolicyPresets[policy]; ok {
+		idRegExp = regexp.MustCompile(preset.pattern)
+		idDescription = preset.description
+
+		return nil
+	}
+
+	re, err := regexp.Compile(`^(?:` + policy + `)$`) // whole key must match, not just part of it
+	if err != nil {
+		return fmt.Errorf("invalid object key policy %q: %w", policy, err)
+	}
+
+	idRegExp = re
+	idDescription = fmt.Sprintf("matching %s", policy)
+
+	return nil
+}
+
+func InvalidIDMessage() string {
+	return "Invalid ID, must be " + idDescription
 }
 
 func IsValidID(id string) bool {
+	if len(id) > maxIDLength {
+		return false
+	}
+
+	for _, segment := range strings.Split(id, "/") {
+		if segment == "." || segment == ".." { // would be rewritten by path cleaning
+			return false
+		}
+	}
+
 	return idRegExp.MatchString(id)
 }
 
@@ -23,7 +95,10 @@ func GetID(r *http.Request, w http.ResponseWriter) string {
 		vars = make(map[string]string)
 	}
 
-	id := vars["id"]
+	id, err := url.PathUnescape(vars["id"]) // router matches on encoded path
+	if err != nil {
+		return ""
+	}
 
 	if !IsValidID(id) {
 		return ""
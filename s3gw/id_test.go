@@ -0,0 +1,40 @@
+package s3gw
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCustomKeyPolicyMatchesWholeKey(t *testing.T) {
+	defer func() {
+		idRegExp = regexp.MustCompile(keyPolicyPresets["strict"].pattern)
+		idDescription = keyPolicyPresets["strict"].description
+	}()
+
+	t.Setenv(ObjectKeyPolicyEnvKey, `[a-z]+|[0-9]+`)
+
+	err := ConfigureKeyPolicy()
+	if err != nil {
+		t.Fatalf("Expected valid policy, got %v", err)
+	}
+
+	for id, expected := range map[string]bool{
+		"report":     true,
+		"42":         true,
+		"report42":   false,
+		"Report":     false,
+		"../report":  false,
+		"report.pdf": false,
+		"":           false,
+	} {
+		if IsValidID(id) != expected {
+			t.Errorf("Expected key %q to be valid: %v", id, expected)
+		}
+	}
+
+	t.Setenv(ObjectKeyPolicyEnvKey, `[a-z`)
+
+	if err := ConfigureKeyPolicy(); err == nil {
+		t.Errorf("Expected invalid policy to be rejected")
+	}
+}
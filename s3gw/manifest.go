@@ -0,0 +1,298 @@
+package s3gw
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const ( // internal object metadata, never exposed to clients
+	metaLayout = "Gw-Layout"
+	metaSize   = "Gw-Size"
+	metaETag   = "Gw-Etag" // ETag of logical object, head object ETag is the manifest's
+)
+
+const (
+	layoutManifest = "manifest"
+)
+
+type Manifest struct { // stored as object body at primary owner, points to parts in internal bucket
+	UploadID  string         `json:"uploadId,omitempty"`
+	ChunkSize int64          `json:"chunkSize,omitempty"` // fixed size parts of striped object
+	Size      int64          `json:"size"`
+	ETag      string         `json:"etag,omitempty"`
+	Parts     []ManifestPart `json:"parts"`
+	Erasure   *ErasureInfo   `json:"erasure,omitempty"`
+	Dedup     *DedupRef      `json:"dedup,omitempty"` // single part is shared blob
+}
+
+type ManifestPart struct {
+	RingKey string `json:"ringKey"`
+	Key     string `json:"key"`
+	Size    int64  `json:"size"`
+	ETag    string `json:"etag"`
+
+	Encrypted bool `json:"encrypted,omitempty"` // wrapped data key is in part metadata, Size is plaintext size
+}
+
+func metaValue(m map[string]string, key string) string { // listings return metadata with "X-Amz-Meta-" prefix, stat without
+	for k, v := range m {
+		if strings.EqualFold(k, key) || strings.EqualFold(k, "X-Amz-Meta-"+key) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func publicMetadata(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+
+	for k, v := range m {
+		name := strings.TrimPrefix(strings.TrimPrefix(k, "X-Amz-Meta-"), "x-amz-meta-")
+		if strings.HasPrefix(strings.ToLower(name), "gw-") {
+			continue
+		}
+
+		out[name] = v
+	}
+
+	return out
+}
+
+func logicalSize(info minio.ObjectInfo) int64 {
+	if metaValue(info.UserMetadata, metaEncryption) != "" {
+		return openedSize(info.Size)
+	}
+
+	if v := metaValue(info.UserMetadata, metaSize); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+
+	return info.Size
+}
+
+func logicalETag(info minio.ObjectInfo) string {
+	if v := metaValue(info.UserMetadata, metaETag); v != "" {
+		return v
+	}
+
+	return info.ETag
+}
+
+func MultipartETag(etags []string) string { // S3 style "md5 of md5s" with parts count suffix
+	h := md5.New()
+
+	for _, etag := range etags {
+		sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+		if err != nil || len(sum) != md5.Size { // part itself was multipart on backend
+			sum = []byte(etag)
+		}
+
+		h.Write(sum)
+	}
+
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(h.Sum(nil)), len(etags))
+}
+
+func (s *Store) readManifest(ctx context.Context, client *minio.Client, bucketName, id, versionID string) (*Manifest, error) {
+	object, err := client.GetObject(ctx, bucketName, id, minio.GetObjectOptions{VersionID: versionID})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return decodeManifest(object, id)
+}
+
+func decodeManifest(r io.Reader, id string) (*Manifest, error) {
+	var m Manifest
+
+	err := json.NewDecoder(io.LimitReader(r, 64<<20)).Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of object %q: %w", id, err)
+	}
+
+	return &m, nil
+}
+
+func manifestMetadata(m *Manifest, opts PutOptions) map[string]string {
+	userMetadata := opts.Checksums.metadata(opts.UserMetadata)
+
+	userMetadata[metaLayout] = layoutManifest
+	userMetadata[metaSize] = strconv.FormatInt(m.Size, 10)
+	userMetadata[metaETag] = m.ETag
+
+	return userMetadata
+}
+
+func (s *Store) putManifest(ctx context.Context, client *minio.Client, bucketName, id string, m *Manifest, opts PutOptions) (minio.UploadInfo, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return client.PutObject(ctx, bucketName, id, strings.NewReader(string(data)), int64(len(data)),
+		minio.PutObjectOptions{
+			ContentType:  contentType,
+			UserMetadata: manifestMetadata(m, opts),
+		},
+	)
+}
+
+func (s *Store) removeManifestParts(ctx context.Context, m *Manifest) error {
+	if m.Dedup != nil { // blob is removed by collector once unreferenced
+		return s.removeInternal(ctx, blobRingKey(m.Dedup.SHA256), m.Dedup.Ref)
+	}
+
+	var errs []error
+
+	for _, part := range m.Parts {
+		errs = append(errs, s.removeInternal(ctx, part.RingKey, part.Key))
+	}
+
+	if m.Erasure != nil {
+		for _, shard := range m.Erasure.Shards {
+			bDef, ok := s.backends.Get(shard.Backend)
+			if !ok { // backend left cluster together with shard
+				continue
+			}
+
+			errs = append(errs, s.removeInternalAt(ctx, bDef, shard.Key))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) getPart(ctx context.Context, part ManifestPart, offset, length int64) (io.ReadCloser, error) {
+	if !part.Encrypted {
+		return s.getInternal(ctx, part.RingKey, part.Key, offset, length)
+	}
+
+	client, backendID := s.backends.Locate(part.RingKey)
+	if client == nil {
+		return nil, ErrNoBackend
+	}
+
+	start, n := sealedRange(part.Size, offset, length)
+
+	opts := minio.GetObjectOptions{}
+
+	err := opts.SetRange(start, start+n-1)
+	if err != nil {
+		return nil, err
+	}
+
+	body, info, _, err := minio.Core{Client: client}.GetObject(ctx, s.internalBucketName, part.Key, opts) // data key comes with ranged response
+	if err != nil {
+		return nil, objectError(err, part.Key, backendID)
+	}
+
+	env := envelopeFromMetadata(info.UserMetadata)
+	if env == nil {
+		body.Close()
+
+		return nil, fmt.Errorf("part %q on %q has no data key", part.Key, backendID)
+	}
+
+	return s.open(ctx, env, body, part.Size, offset, length)
+}
+
+type manifestReader struct { // streams byte range of manifest object part by part
+	ctx   context.Context
+	store *Store
+	parts []ManifestPart
+
+	offset    int64 // offset within first remaining part
+	remaining int64
+
+	cur     io.ReadCloser
+	curLeft int64
+}
+
+func (s *Store) newManifestReader(ctx context.Context, m *Manifest, offset, length int64) *manifestReader {
+	parts := m.Parts
+
+	for len(parts) > 0 && offset >= parts[0].Size { // skip parts before range
+		offset -= parts[0].Size
+		parts = parts[1:]
+	}
+
+	return &manifestReader{
+		ctx:       ctx,
+		store:     s,
+		parts:     parts,
+		offset:    offset,
+		remaining: length,
+	}
+}
+
+func (r *manifestReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if r.cur == nil {
+		if len(r.parts) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		part := r.parts[0]
+		length := min(part.Size-r.offset, r.remaining)
+
+		rc, err := r.store.getPart(r.ctx, part, r.offset, length)
+		if err != nil {
+			return 0, err
+		}
+
+		r.cur = rc
+		r.curLeft = length
+		r.parts = r.parts[1:]
+		r.offset = 0
+	}
+
+	if int64(len(p)) > r.curLeft {
+		p = p[:r.curLeft]
+	}
+
+	n, err := r.cur.Read(p)
+	r.curLeft -= int64(n)
+	r.remaining -= int64(n)
+
+	if r.curLeft == 0 {
+		r.cur.Close()
+		r.cur = nil
+
+		return n, nil
+	}
+
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF // part is shorter than manifest says
+	}
+
+	return n, err
+}
+
+func (r *manifestReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+
+	return nil
+}
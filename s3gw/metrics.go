@@ -0,0 +1,13 @@
+package s3gw
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "gateway"
+
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,450 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	MultipartUploadTTLEnvKey  = "MULTIPART_UPLOAD_TTL"
+	MultipartGCIntervalEnvKey = "MULTIPART_GC_INTERVAL"
+)
+
+const maxPartNumber = 10000
+
+var (
+	ErrNoSuchUpload     = errors.New("upload does not exist")
+	ErrInvalidPart      = errors.New("one or more of the specified parts could not be found")
+	ErrInvalidPartOrder = errors.New("the list of parts was not in ascending order")
+)
+
+type multipartUpload struct { // upload state, stored in internal bucket at object owner
+	Namespace    string            `json:"namespace"`
+	ID           string            `json:"id"`
+	UploadID     string            `json:"uploadId"`
+	ContentType  string            `json:"contentType,omitempty"`
+	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+	Initiated    time.Time         `json:"initiated"`
+}
+
+type CompletePart struct {
+	PartNumber int
+	ETag       string
+}
+
+func uploadRecordKey(uploadID string) string {
+	return "uploads/" + uploadID
+}
+
+func uploadPartsPrefix(uploadID string) string {
+	return "parts/" + uploadID + "/"
+}
+
+func uploadPartKey(uploadID string, partNumber int) string {
+	return fmt.Sprintf("%s%05d", uploadPartsPrefix(uploadID), partNumber)
+}
+
+func uploadPartRingKey(uploadID string, partNumber int) string { // parts are spread over ring independently of object
+	return uploadID + "/" + strconv.Itoa(partNumber)
+}
+
+func isValidUploadID(uploadID string) bool {
+	b, err := hex.DecodeString(uploadID)
+
+	return err == nil && len(b) == 16
+}
+
+func ParsePartNumber(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxPartNumber {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (s *Store) InitiateMultipartUpload(ctx context.Context, namespace, id string, opts PutOptions) (string, error) {
+	if opts.SSEC != nil { // parts are gateway owned objects
+		return "", ErrSSECNotSupported
+	}
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	upload := multipartUpload{
+		Namespace:    namespace,
+		ID:           id,
+		UploadID:     hex.EncodeToString(b),
+		ContentType:  opts.ContentType,
+		UserMetadata: withExpires(opts.UserMetadata, opts.Expires),
+		Initiated:    time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return "", err
+	}
+
+	_, _, err = s.putInternal(ctx, RingKey(namespace, id), uploadRecordKey(upload.UploadID),
+		bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return upload.UploadID, nil
+}
+
+func (s *Store) getMultipartUpload(ctx context.Context, namespace, id, uploadID string) (*multipartUpload, error) {
+	if !isValidUploadID(uploadID) { // upload ID becomes part of internal keys
+		return nil, fmt.Errorf("upload %q: %w", uploadID, ErrNoSuchUpload)
+	}
+
+	rc, err := s.getInternal(ctx, RingKey(namespace, id), uploadRecordKey(uploadID), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var upload multipartUpload
+
+	err = json.NewDecoder(rc).Decode(&upload)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" || minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return nil, fmt.Errorf("upload %q: %w", uploadID, ErrNoSuchUpload)
+		}
+
+		return nil, fmt.Errorf("failed to read upload %q: %w", uploadID, err)
+	}
+
+	if upload.Namespace != namespace || upload.ID != id { // upload ID belongs to another object
+		return nil, fmt.Errorf("upload %q: %w", uploadID, ErrNoSuchUpload)
+	}
+
+	return &upload, nil
+}
+
+func (s *Store) UploadPart(ctx context.Context, namespace, id, uploadID string, partNumber int, body io.Reader, size int64) (ObjectInfo, error) {
+	_, err := s.getMultipartUpload(ctx, namespace, id, uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	data, stored, meta, err := s.sealPart(ctx, namespace, body, size)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, backendID, err := s.putInternal(ctx, uploadPartRingKey(uploadID, partNumber),
+		uploadPartKey(uploadID, partNumber), data, stored, meta)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Key:     id,
+		Size:    logicalSize(minio.ObjectInfo{Size: info.Size, UserMetadata: meta}),
+		ETag:    info.ETag,
+		Backend: backendID,
+	}, nil
+}
+
+func (s *Store) listUploadParts(ctx context.Context, uploadID string) (map[int]internalObject, error) {
+	objects, err := s.listInternal(ctx, uploadPartsPrefix(uploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make(map[int]internalObject, len(objects))
+
+	for _, object := range objects {
+		partNumber, ok := ParsePartNumber(strings.TrimLeft(strings.TrimPrefix(object.Key, uploadPartsPrefix(uploadID)), "0"))
+		if !ok {
+			continue
+		}
+
+		parts[partNumber] = object
+	}
+
+	return parts, nil
+}
+
+func (s *Store) CompleteMultipartUpload(ctx context.Context, namespace, id, uploadID string, completeParts []CompletePart) (ObjectInfo, error) {
+	upload, err := s.getMultipartUpload(ctx, namespace, id, uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.invalidateCached(namespace, id)
+
+	uploaded, err := s.listUploadParts(ctx, uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if len(completeParts) == 0 { // complete with every uploaded part
+		for partNumber, part := range uploaded {
+			completeParts = append(completeParts, CompletePart{
+				PartNumber: partNumber,
+				ETag:       part.ETag,
+			})
+		}
+
+		sort.Slice(completeParts, func(i, j int) bool {
+			return completeParts[i].PartNumber < completeParts[j].PartNumber
+		})
+	}
+
+	if len(completeParts) == 0 {
+		return ObjectInfo{}, ErrInvalidPart
+	}
+
+	m := &Manifest{
+		UploadID: uploadID,
+	}
+
+	etags := make([]string, 0, len(completeParts))
+
+	for i, cp := range completeParts {
+		if i > 0 && cp.PartNumber <= completeParts[i-1].PartNumber {
+			return ObjectInfo{}, ErrInvalidPartOrder
+		}
+
+		part, ok := uploaded[cp.PartNumber]
+		if !ok || strings.Trim(cp.ETag, `"`) != strings.Trim(part.ETag, `"`) {
+			return ObjectInfo{}, fmt.Errorf("part %d: %w", cp.PartNumber, ErrInvalidPart)
+		}
+
+		encrypted, err := s.isPartEncrypted(ctx, namespace, cp.PartNumber, part)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+
+		size := part.Size
+		if encrypted {
+			size = openedSize(part.Size)
+		}
+
+		m.Parts = append(m.Parts, ManifestPart{
+			RingKey:   uploadPartRingKey(uploadID, cp.PartNumber),
+			Key:       part.Key,
+			Size:      size,
+			ETag:      part.ETag,
+			Encrypted: encrypted,
+		})
+		m.Size += size
+
+		etags = append(etags, part.ETag)
+
+		delete(uploaded, cp.PartNumber)
+	}
+
+	m.ETag = MultipartETag(etags)
+
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.lockObject(namespace, id)()
+
+	_, err = s.getMultipartUpload(ctx, namespace, id, uploadID) // concurrent or retried completion may have finished meanwhile
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	err = s.ensureBucket(ctx, BackendDef{MinioClient: client, Name: backendID}, bucketName)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to ensure S3 bucket %q existance on %q: %w",
+			bucketName, backendID, err)
+	}
+
+	previous := s.overwrittenManifest(ctx, client, namespace, id)
+	if previous != nil && previous.UploadID == uploadID { // upload record outlived committed manifest, parts are shared
+		previous = nil
+	}
+
+	_, err = s.putManifest(ctx, client, bucketName, id, m,
+		PutOptions{
+			ContentType:  upload.ContentType,
+			UserMetadata: upload.UserMetadata,
+		},
+	)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to upload object %q to %q: %w",
+			id, backendID, s.checkBucketError(backendID, bucketName, err))
+	}
+
+	s.releaseManifest(ctx, previous)
+	s.removeErasureHeadCopies(ctx, namespace, id, previous)
+
+	for partNumber, part := range uploaded { // uploaded but not completed parts are dropped
+		err = s.removeInternal(ctx, uploadPartRingKey(uploadID, partNumber), part.Key)
+		if err != nil {
+			log.Printf("Failed to remove unused part %d of upload %q: %v", partNumber, uploadID, err)
+		}
+	}
+
+	err = s.removeInternal(ctx, RingKey(namespace, id), uploadRecordKey(uploadID))
+	if err != nil {
+		log.Printf("Failed to remove completed upload %q, it is left for garbage collector: %v", uploadID, err)
+	}
+
+	return ObjectInfo{
+		Key:     id,
+		Size:    m.Size,
+		ETag:    m.ETag,
+		Backend: backendID,
+	}, nil
+}
+
+func (s *Store) isPartEncrypted(ctx context.Context, namespace string, partNumber int, part internalObject) (bool, error) {
+	if metaValue(part.UserMetadata, metaEncryption) != "" {
+		return true, nil
+	}
+
+	if !s.namespaces.For(namespace).encrypted() { // listings do not always carry metadata
+		return false, nil
+	}
+
+	info, err := s.statInternalAt(ctx, part.Backend, part.Key)
+	if err != nil {
+		return false, fmt.Errorf("part %d: %w", partNumber, err)
+	}
+
+	return envelopeFromMetadata(info.UserMetadata) != nil, nil
+}
+
+func (s *Store) AbortMultipartUpload(ctx context.Context, namespace, id, uploadID string) error {
+	_, err := s.getMultipartUpload(ctx, namespace, id, uploadID)
+	if err != nil {
+		return err
+	}
+
+	return s.removeMultipartUpload(ctx, namespace, id, uploadID)
+}
+
+func (s *Store) removeMultipartUpload(ctx context.Context, namespace, id, uploadID string) error {
+	parts, err := s.listUploadParts(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	for partNumber, part := range parts {
+		err = s.removeInternal(ctx, uploadPartRingKey(uploadID, partNumber), part.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.removeInternal(ctx, RingKey(namespace, id), uploadRecordKey(uploadID))
+}
+
+func (s *Store) isUploadCommitted(ctx context.Context, upload *multipartUpload) bool { // completion may have crashed before removing upload state
+	client, _, err := s.locate(upload.Namespace, upload.ID)
+	if err != nil {
+		return false
+	}
+
+	m := s.previousManifest(ctx, client, s.BucketName(upload.Namespace), upload.ID)
+
+	return m != nil && m.UploadID == upload.UploadID
+}
+
+func RunMultipartGC(ctx context.Context, store *Store) {
+	ttl := MustGetDurationFromEnv(MultipartUploadTTLEnvKey)
+	interval := MustGetDurationFromEnv(MultipartGCIntervalEnvKey)
+
+	if ttl <= 0 || interval <= 0 {
+		log.Printf("Multipart upload garbage collection is disabled")
+
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := store.collectAbandonedUploads(ctx, ttl)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Multipart upload garbage collection failed: %v", err)
+		}
+
+		if removed > 0 {
+			log.Printf("Removed %d abandoned multipart uploads", removed)
+		}
+	}
+}
+
+func (s *Store) collectAbandonedUploads(ctx context.Context, ttl time.Duration) (int, error) {
+	records, err := s.listInternal(ctx, "uploads/")
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		if time.Since(record.LastModified) < ttl {
+			continue
+		}
+
+		object, err := record.Backend.MinioClient.GetObject(ctx, s.internalBucketName, record.Key, minio.GetObjectOptions{})
+		if err != nil {
+			return removed, err
+		}
+
+		var upload multipartUpload
+
+		err = json.NewDecoder(object).Decode(&upload)
+		object.Close()
+
+		if err != nil {
+			log.Printf("Skipping unreadable upload record %q on %q: %v", record.Key, record.Backend.Name, err)
+
+			continue
+		}
+
+		if s.isUploadCommitted(ctx, &upload) {
+			err = s.removeInternal(ctx, RingKey(upload.Namespace, upload.ID), record.Key)
+		} else {
+			err = s.removeMultipartUpload(ctx, upload.Namespace, upload.ID, upload.UploadID)
+		}
+
+		if err != nil {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,177 @@
+package s3gw
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+/*
+	TEST `initiate`: curl -XPOST 'http://127.0.0.1:3000/object/id42?uploads'
+	TEST `part`:     curl -XPUT -d 'DataContent' 'http://127.0.0.1:3000/object/id42?uploadId=<id>&partNumber=1'
+	TEST `complete`: curl -XPOST 'http://127.0.0.1:3000/object/id42?uploadId=<id>'
+	TEST `abort`:    curl -XDELETE 'http://127.0.0.1:3000/object/id42?uploadId=<id>'
+*/
+
+type completePartRequest struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+func getObjectRef(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		http.Error(w,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
+		)
+
+		return "", "", false
+	}
+
+	id := GetID(r, w)
+	if id == "" {
+		http.Error(w,
+			InvalidIDMessage(),
+			http.StatusBadRequest,
+		)
+
+		return "", "", false
+	}
+
+	return namespace, id, true
+}
+
+func HandleMultipartInitiate(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	sse, err := SSECFromRequest(r.Header)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	expires, err := ExpiresFromRequest(r.Header)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	uploadID, err := store.InitiateMultipartUpload(r.Context(), namespace, id,
+		PutOptions{ContentType: r.Header.Get("Content-Type"), SSEC: sse, Expires: expires})
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(uploadID + "\n"))
+
+	log.Printf("Multipart upload %q of object %q initiated", uploadID, id)
+}
+
+func HandleMultipartUploadPart(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	partNumber, ok := ParsePartNumber(query.Get("partNumber"))
+	if !ok {
+		http.Error(w,
+			"Invalid part number, must be between 1 and 10000",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	defer r.Body.Close()
+
+	info, err := store.UploadPart(r.Context(), namespace, id, uploadID, partNumber, r.Body, r.ContentLength)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("Part %d of multipart upload %q uploaded to %q", partNumber, uploadID, info.Backend)
+}
+
+func HandleMultipartComplete(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+
+	defer r.Body.Close()
+
+	var req []completePartRequest // empty body completes upload with all uploaded parts
+
+	err := json.NewDecoder(io.LimitReader(r.Body, 2<<20)).Decode(&req)
+	if err != nil && err != io.EOF {
+		http.Error(w,
+			"Invalid part list, must be JSON array of objects with partNumber and etag",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	parts := make([]CompletePart, 0, len(req))
+	for _, part := range req {
+		parts = append(parts, CompletePart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+	}
+
+	info, err := store.CompleteMultipartUpload(r.Context(), namespace, id, uploadID, parts)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusCreated)
+
+	log.Printf("Multipart upload %q of object %q completed on %q", uploadID, id, info.Backend)
+}
+
+func HandleMultipartAbort(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+
+	err := store.AbortMultipartUpload(r.Context(), namespace, id, uploadID)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	log.Printf("Multipart upload %q of object %q aborted", uploadID, id)
+}
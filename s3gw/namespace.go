@@ -0,0 +1,151 @@
+package s3gw
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	S3NamespaceBucketPrefixEnvKey = "S3_NAMESPACE_BUCKET_PREFIX"
+)
+
+const DefaultNamespace = "default"
+
+var namespaceRegExp *regexp.Regexp
+
+func init() {
+	namespaceRegExp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,30}[a-z0-9]$`) // must stay a valid S3 bucket name with prefix
+}
+
+func IsValidNamespace(namespace string) bool {
+	return namespaceRegExp.MatchString(namespace)
+}
+
+func GetNamespace(r *http.Request) string {
+	vars := mux.Vars(r)
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+
+	v, ok := vars["namespace"]
+	if !ok {
+		return DefaultNamespace // routes without namespace work against default one
+	}
+
+	namespace, err := url.PathUnescape(v)
+	if err != nil {
+		return ""
+	}
+
+	if !IsValidNamespace(namespace) {
+		return ""
+	}
+
+	return namespace
+}
+
+func NamespaceBucketName(namespace, defaultBucketName string) string {
+	if namespace == DefaultNamespace {
+		return defaultBucketName
+	}
+
+	return os.Getenv(S3NamespaceBucketPrefixEnvKey) + namespace
+}
+
+func RingKey(namespace, id string) string {
+	if namespace == DefaultNamespace {
+		return id // keep placement of objects stored before namespaces were introduced
+	}
+
+	return namespace + "/" + id
+}
+
+func HandleNamespaceCreate(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		http.Error(w,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	err := store.CreateNamespace(r.Context(), namespace)
+	if err != nil {
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	log.Printf("Namespace %q created", namespace)
+}
+
+func HandleNamespaceList(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespaces, err := store.ListNamespaces(r.Context())
+	if err != nil {
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	names := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		names = append(names, namespace.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(strings.Join(names, " ")))
+
+	log.Printf("Fetched all namespaces")
+}
+
+func HandleNamespaceDelete(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		http.Error(w,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	err := store.DeleteNamespace(r.Context(), namespace)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNamespaceProtected):
+		http.Error(w, CapitalizeErrorString(err), http.StatusForbidden)
+
+		return
+	case errors.Is(err, ErrNamespaceNotFound):
+		http.Error(w, CapitalizeErrorString(err), http.StatusNotFound)
+
+		return
+	case errors.Is(err, ErrNamespaceNotEmpty):
+		http.Error(w, CapitalizeErrorString(err), http.StatusConflict)
+
+		return
+	default:
+		http.Error(w, CapitalizeErrorString(err), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("Namespace %q deleted", namespace)
+}
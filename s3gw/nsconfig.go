@@ -0,0 +1,132 @@
+package s3gw
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+const (
+	NamespaceConfigFileEnvKey = "NAMESPACE_CONFIG_FILE"
+)
+
+const (
+	StorageSingle  = "single"  // one copy at ring owner
+	StorageErasure = "erasure" // Reed-Solomon shards on distinct ring members
+	StorageDedup   = "dedup"   // bodies stored once per SHA-256 at hash owner, objects reference them
+)
+
+const namespaceConfigWildcard = "*"
+
+type NamespaceConfig struct {
+	Storage      string `json:"storage,omitempty"`
+	DataShards   int    `json:"dataShards,omitempty"`
+	ParityShards int    `json:"parityShards,omitempty"`
+	Compression  string `json:"compression,omitempty"` // none, zstd or gzip
+	Encryption   string `json:"encryption,omitempty"`  // none or envelope
+	Versioning   bool   `json:"versioning,omitempty"`  // backend buckets keep noncurrent versions, their parts are never released
+
+	ExpireAfter string          `json:"expireAfter,omitempty"` // Go duration since last write, X-Object-Expires of object wins
+	Lifecycle   []LifecycleRule `json:"lifecycle,omitempty"`   // per key prefix, override ExpireAfter
+}
+
+type NamespaceConfigs struct {
+	Namespaces map[string]NamespaceConfig `json:"namespaces"` // "*" applies to namespaces without own entry
+}
+
+func LoadNamespaceConfigs() (*NamespaceConfigs, error) { // empty config means every namespace uses defaults
+	path := os.Getenv(NamespaceConfigFileEnvKey)
+	if path == "" {
+		return &NamespaceConfigs{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read namespace config: %w", err)
+	}
+
+	var cfg NamespaceConfigs
+
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse namespace config %q: %w", path, err)
+	}
+
+	for name, nc := range cfg.Namespaces {
+		if name != namespaceConfigWildcard && !IsValidNamespace(name) {
+			return nil, fmt.Errorf("invalid namespace %q in namespace config", name)
+		}
+
+		err = nc.validate()
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", name, err)
+		}
+	}
+
+	log.Printf("Loaded configuration of %d namespaces from %q", len(cfg.Namespaces), path)
+
+	return &cfg, nil
+}
+
+func (c *NamespaceConfigs) For(namespace string) NamespaceConfig {
+	if c == nil {
+		return NamespaceConfig{}
+	}
+
+	if nc, ok := c.Namespaces[namespace]; ok {
+		return nc
+	}
+
+	return c.Namespaces[namespaceConfigWildcard]
+}
+
+func (nc NamespaceConfig) compression() string { // empty when objects are stored verbatim
+	if nc.Compression == CompressionNone {
+		return ""
+	}
+
+	return nc.Compression
+}
+
+func (nc NamespaceConfig) encrypted() bool {
+	return nc.Encryption == EncryptionEnvelope
+}
+
+func (nc NamespaceConfig) validate() error {
+	switch nc.Storage {
+	case "", StorageSingle, StorageDedup:
+	case StorageErasure:
+		if nc.DataShards < 1 || nc.ParityShards < 1 || nc.DataShards+nc.ParityShards > 256 {
+			return fmt.Errorf("erasure storage needs at least 1 data and 1 parity shard, 256 in total")
+		}
+	default:
+		return fmt.Errorf("unknown storage policy %q", nc.Storage)
+	}
+
+	switch nc.Compression {
+	case "", CompressionNone:
+	case CompressionZstd, CompressionGzip:
+		if nc.Storage == StorageErasure || nc.Storage == StorageDedup {
+			return fmt.Errorf("compression is not supported with erasure or dedup storage")
+		}
+	default:
+		return fmt.Errorf("unknown compression %q", nc.Compression)
+	}
+
+	switch nc.Encryption {
+	case "", EncryptionNone:
+	case EncryptionEnvelope:
+		if nc.Storage == StorageErasure || nc.Storage == StorageDedup || nc.compression() != "" { // per-object data keys defeat dedup
+			return fmt.Errorf("encryption is not supported with erasure or dedup storage or compression")
+		}
+	default:
+		return fmt.Errorf("unknown encryption %q", nc.Encryption)
+	}
+
+	if nc.Versioning && (nc.Storage == StorageErasure || nc.Storage == StorageDedup) { // shards and blob references follow current version only
+		return fmt.Errorf("versioning is not supported with erasure or dedup storage")
+	}
+
+	return validateLifecycle(nc)
+}
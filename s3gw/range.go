@@ -0,0 +1,112 @@
+package s3gw
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRange = errors.New("requested range is not satisfiable")
+
+type ByteRange struct { // single "bytes=" range as sent by client, resolved against object size later
+	Start int64 // -1 for suffix range
+	End   int64 // -1 for open ended range, suffix length for suffix range
+}
+
+func ParseByteRange(header string) (*ByteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") { // multiple ranges are not supported
+		return nil, ErrInvalidRange
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, ErrInvalidRange
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, ErrInvalidRange
+		}
+
+		return &ByteRange{Start: -1, End: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	if last == "" {
+		return &ByteRange{Start: start, End: -1}, nil
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil, ErrInvalidRange
+	}
+
+	return &ByteRange{Start: start, End: end}, nil
+}
+
+func (br *ByteRange) Resolve(size int64) (offset, length int64, err error) {
+	if br == nil {
+		return 0, size, nil
+	}
+
+	if br.Start < 0 { // suffix
+		if br.End > size {
+			return 0, size, nil
+		}
+
+		return size - br.End, br.End, nil
+	}
+
+	if br.Start >= size {
+		return 0, 0, ErrInvalidRange
+	}
+
+	end := br.End
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+
+	return br.Start, end - br.Start + 1, nil
+}
+
+func ContentRange(offset, length, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size)
+}
+
+func (br *ByteRange) String() string { // as sent to backend
+	switch {
+	case br.Start < 0:
+		return fmt.Sprintf("bytes=-%d", br.End)
+	case br.End < 0:
+		return fmt.Sprintf("bytes=%d-", br.Start)
+	default:
+		return fmt.Sprintf("bytes=%d-%d", br.Start, br.End)
+	}
+}
+
+func ParseContentRange(header string) (offset, length, size int64, err error) { // "bytes first-last/size" of backend response
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, 0, ErrInvalidRange
+	}
+
+	var last int64
+
+	_, err = fmt.Sscanf(spec, "%d-%d/%d", &offset, &last, &size)
+	if err != nil || last < offset || last >= size {
+		return 0, 0, 0, ErrInvalidRange
+	}
+
+	return offset, last - offset + 1, size, nil
+}
@@ -0,0 +1,253 @@
+package s3gw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+type RewrapReport struct {
+	Running        bool      `json:"running"`
+	KeyID          string    `json:"keyId"` // master key data keys are wrapped with
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	ObjectsScanned int64     `json:"objectsScanned"`
+	Rewrapped      int64     `json:"rewrapped"`
+	Failed         int64     `json:"failed"`
+	Error          string    `json:"error,omitempty"`
+}
+
+type KeyRewrapper struct { // rewraps data keys of old master keys, stored data is not rewritten
+	store *Store
+
+	trigger chan struct{}
+
+	mu      sync.Mutex
+	last    RewrapReport
+	current *RewrapReport
+}
+
+func NewKeyRewrapper(store *Store) *KeyRewrapper {
+	return &KeyRewrapper{
+		store:   store,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+func (kr *KeyRewrapper) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-kr.trigger:
+		}
+
+		report := kr.rewrap(ctx)
+
+		log.Printf("Key rewrap checked %d objects, rewrapped %d to %q, %d failed",
+			report.ObjectsScanned, report.Rewrapped, report.KeyID, report.Failed)
+	}
+}
+
+func (kr *KeyRewrapper) Trigger() bool { // false when run is already pending
+	select {
+	case kr.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (kr *KeyRewrapper) Report() RewrapReport {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if kr.current != nil {
+		return *kr.current
+	}
+
+	return kr.last
+}
+
+func (kr *KeyRewrapper) rewrap(ctx context.Context) RewrapReport {
+	kr.mu.Lock()
+	kr.current = &RewrapReport{
+		Running:   true,
+		KeyID:     kr.store.keys.CurrentKeyID(),
+		StartedAt: time.Now().UTC(),
+	}
+	kr.mu.Unlock()
+
+	var errs []error
+
+	for _, bDef := range kr.store.backends.GetMembers() {
+		err := kr.rewrapBackend(ctx, bDef)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	report := *kr.current
+	report.Running = false
+	report.FinishedAt = time.Now().UTC()
+
+	if err := errors.Join(errs...); err != nil {
+		report.Error = err.Error()
+	}
+
+	kr.last = report
+	kr.current = nil
+
+	return report
+}
+
+func (kr *KeyRewrapper) rewrapBackend(ctx context.Context, bDef BackendDef) error {
+	buckets, err := bDef.MinioClient.ListBuckets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list S3 buckets on %q: %w", bDef.Name, err)
+	}
+
+	for _, bucket := range buckets {
+		if _, ok := kr.store.bucketNamespace(bucket.Name); !ok && bucket.Name != kr.store.internalBucketName { // parts are encrypted too
+			continue
+		}
+
+		startAfter := ""
+
+		for {
+			objects, err := ListObjectsInBucket(ctx, bDef.MinioClient, bucket.Name, "", startAfter, scrubListPageSize)
+			if err != nil {
+				return fmt.Errorf("failed to list keys in S3 bucket %q on %q: %w", bucket.Name, bDef.Name, err)
+			}
+
+			for _, object := range objects {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				rewrapped, err := kr.rewrapObject(ctx, bDef, bucket.Name, object.Key)
+
+				kr.mu.Lock()
+				kr.current.ObjectsScanned++
+
+				switch {
+				case err != nil:
+					kr.current.Failed++
+				case rewrapped:
+					kr.current.Rewrapped++
+				}
+				kr.mu.Unlock()
+
+				if err != nil {
+					log.Printf("Failed to rewrap data key of %q in %q on %q: %v", object.Key, bucket.Name, bDef.Name, err)
+				}
+
+				startAfter = object.Key
+			}
+
+			if len(objects) < scrubListPageSize {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (kr *KeyRewrapper) rewrapObject(ctx context.Context, bDef BackendDef, bucketName, key string) (bool, error) {
+	info, err := bDef.MinioClient.StatObject(ctx, bucketName, key, minio.GetObjectOptions{}) // listings do not always carry metadata
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" { // deleted since listing
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	env := envelopeFromMetadata(info.UserMetadata)
+	if env == nil || env.keyID == kr.store.keys.CurrentKeyID() {
+		return false, nil
+	}
+
+	rewrapped, err := kr.store.keys.rewrap(ctx, env)
+	if err != nil {
+		return false, err
+	}
+
+	meta := make(map[string]string, len(info.UserMetadata)+1)
+	for k, v := range info.UserMetadata {
+		meta[k] = v
+	}
+
+	rewrapped.metadata(meta)
+	meta["Content-Type"] = info.ContentType
+
+	_, err = bDef.MinioClient.CopyObject(ctx, // metadata can only be replaced by copying object onto itself
+		minio.CopyDestOptions{
+			Bucket:          bucketName,
+			Object:          key,
+			UserMetadata:    meta,
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{
+			Bucket:    bucketName,
+			Object:    key,
+			MatchETag: info.ETag, // object overwritten since stat keeps its own data key
+		},
+	)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func HandleRewrapReport(w http.ResponseWriter, r *http.Request, rewrapper *KeyRewrapper) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(rewrapper.Report())
+	if err != nil {
+		log.Printf("Failed to write key rewrap report: %v", err)
+	}
+}
+
+func HandleRewrapStart(w http.ResponseWriter, r *http.Request, rewrapper *KeyRewrapper) {
+	if rewrapper.store.keys == nil {
+		http.Error(w,
+			CapitalizeErrorString(errNoMasterKey),
+			http.StatusConflict,
+		)
+
+		return
+	}
+
+	if !rewrapper.Trigger() {
+		http.Error(w,
+			"Key rewrap run is already pending",
+			http.StatusConflict,
+		)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	log.Printf("Key rewrap run requested")
+}
@@ -3,12 +3,15 @@ package s3gw
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/minio/minio-go/v7"
 )
 
 const (
-	S3DefaultBucketNameEnvKey = "S3_DEFAULT_BUCKET_NAME"
+	S3DefaultBucketNameEnvKey  = "S3_DEFAULT_BUCKET_NAME"
+	S3InternalBucketNameEnvKey = "S3_INTERNAL_BUCKET_NAME"
 )
 
 func CheckS3BackendLiveliness(ctx context.Context, client *minio.Client) error {
@@ -42,17 +45,122 @@ func EnsureBucketExists(ctx context.Context, client *minio.Client, bucketName st
 	return client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
 }
 
-func ListObjectsInBucket(ctx context.Context, client *minio.Client, bucketName string) ([]string, error) {
-	out := make([]string, 0)
+func ListObjectsInBucket(ctx context.Context, client *minio.Client, bucketName, prefix, startAfter string, limit int) ([]minio.ObjectInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop listing once limit is reached
+
+	out := make([]minio.ObjectInfo, 0)
 
-	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{})
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		StartAfter:   startAfter,
+		Recursive:    true, // keys can be hierarchical
+		WithMetadata: true, // logical size of layouts is kept in metadata
+	})
 	for object := range objectCh {
 		if object.Err != nil {
 			return nil, object.Err
 		}
 
-		out = append(out, object.Key)
+		out = append(out, object)
+
+		if limit > 0 && len(out) == limit {
+			break
+		}
 	}
 
 	return out, nil
 }
+
+func IsBucketEmpty(ctx context.Context, client *minio.Client, bucketName string) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop listing after first key
+
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive: true,
+		MaxKeys:   1,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return false, object.Err
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func MergeKeyListings(listings ...[]string) []string { // sorted union of per-backend listings
+	seen := make(map[string]struct{})
+	out := make([]string, 0)
+
+	for _, listing := range listings {
+		for _, key := range listing {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			seen[key] = struct{}{}
+			out = append(out, key)
+		}
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+func GroupKeysByDelimiter(keys []string, prefix, delimiter string) (objects, commonPrefixes []string) { // S3 style "directories"
+	if delimiter == "" {
+		return keys, nil
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, key := range keys {
+		rest := strings.TrimPrefix(key, prefix)
+
+		i := strings.Index(rest, delimiter)
+		if i < 0 {
+			objects = append(objects, key)
+
+			continue
+		}
+
+		commonPrefix := prefix + rest[:i+len(delimiter)]
+		if _, ok := seen[commonPrefix]; ok {
+			continue
+		}
+
+		seen[commonPrefix] = struct{}{}
+		commonPrefixes = append(commonPrefixes, commonPrefix)
+	}
+
+	return objects, commonPrefixes
+}
+
+func CopyObjectAcrossBackends(ctx context.Context, src, dst *minio.Client, srcBucket, srcKey, dstBucket, dstKey string) (minio.UploadInfo, error) { // streams through gateway, keeps metadata
+	object, err := src.GetObject(ctx, srcBucket, srcKey, minio.GetObjectOptions{})
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	err = EnsureBucketExists(ctx, dst, dstBucket)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	return dst.PutObject(ctx, dstBucket, dstKey, object, info.Size,
+		minio.PutObjectOptions{
+			ContentType:  info.ContentType,
+			UserMetadata: info.UserMetadata,
+		},
+	)
+}
@@ -0,0 +1,761 @@
+package s3gw
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	S3APIListenAddressEnvKey = "S3_API_LISTEN_ADDRESS"
+)
+
+const (
+	s3XMLNamespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat       = "2006-01-02T15:04:05.000Z"
+	s3MaxKeys          = 1000
+	s3MaxDeleteObjects = 1000
+	s3UserMetaPrefix   = "X-Amz-Meta-"
+)
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	XMLNS   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListBucketResult struct {
+	XMLName        xml.Name         `xml:"ListBucketResult"`
+	XMLNS          string           `xml:"xmlns,attr"`
+	Name           string           `xml:"Name"`
+	Prefix         string           `xml:"Prefix"`
+	Delimiter      string           `xml:"Delimiter,omitempty"`
+	MaxKeys        int              `xml:"MaxKeys"`
+	EncodingType   string           `xml:"EncodingType,omitempty"`
+	IsTruncated    bool             `xml:"IsTruncated"`
+	Contents       []s3Object       `xml:"Contents"`
+	CommonPrefixes []s3CommonPrefix `xml:"CommonPrefixes"`
+
+	// ListObjectsV2
+	KeyCount              *int   `xml:"KeyCount,omitempty"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+
+	// ListObjects (v1)
+	Marker     *string `xml:"Marker,omitempty"`
+	NextMarker string  `xml:"NextMarker,omitempty"`
+}
+
+type s3DeleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type s3Deleted struct {
+	Key string `xml:"Key"`
+}
+
+type s3DeleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type s3DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Deleted []s3Deleted     `xml:"Deleted"`
+	Errors  []s3DeleteError `xml:"Error"`
+}
+
+type s3LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	XMLNS   string   `xml:"xmlns,attr"`
+}
+
+func (a *Authenticator) S3Middleware(next http.Handler) http.Handler {
+	return a.middleware(next, func(w http.ResponseWriter, r *http.Request, status int) {
+		writeS3Error(w, r, status, "AccessDenied", "Access Denied")
+	})
+}
+
+func S3BucketPathHandler(next http.Handler) http.Handler { // SDKs address buckets as "/bucket/", router expects "/bucket"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+
+		if len(path) > 2 && strings.HasSuffix(path, "/") && strings.Count(path, "/") == 2 {
+			r.URL.Path = strings.TrimSuffix(r.URL.Path, "/")
+			r.URL.RawPath = strings.TrimSuffix(r.URL.RawPath, "/")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func S3RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+
+		w.Header().Set("X-Amz-Request-Id", strings.ToUpper(hex.EncodeToString(b)))
+		w.Header().Set("Server", "s3gw")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func HandleS3NotFound(w http.ResponseWriter, r *http.Request) {
+	writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+}
+
+func HandleS3MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+}
+
+func HandleS3ListBuckets(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespaces, err := store.ListNamespaces(r.Context())
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	result := s3ListAllMyBucketsResult{
+		XMLNS: s3XMLNamespace,
+		Owner: s3Owner{
+			ID:          "s3gw",
+			DisplayName: "s3gw",
+		},
+		Buckets: make([]s3Bucket, 0, len(namespaces)),
+	}
+
+	for _, namespace := range namespaces {
+		result.Buckets = append(result.Buckets, s3Bucket{
+			Name:         namespace.Name,
+			CreationDate: namespace.CreationDate.UTC().Format(s3TimeFormat),
+		})
+	}
+
+	writeS3XML(w, http.StatusOK, result)
+}
+
+func HandleS3CreateBucket(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return
+	}
+
+	err := store.CreateNamespace(r.Context(), namespace)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	w.Header().Set("Location", "/"+namespace)
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("Namespace %q created", namespace)
+}
+
+func HandleS3HeadBucket(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return
+	}
+
+	if !s3NamespaceExists(w, r, store, namespace) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func HandleS3DeleteBucket(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return
+	}
+
+	err := store.DeleteNamespace(r.Context(), namespace)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	log.Printf("Namespace %q deleted", namespace)
+}
+
+func HandleS3GetBucketLocation(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return
+	}
+
+	if !s3NamespaceExists(w, r, store, namespace) {
+		return
+	}
+
+	writeS3XML(w, http.StatusOK, s3LocationConstraint{XMLNS: s3XMLNamespace}) // empty means default region
+}
+
+func HandleS3ListObjects(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return
+	}
+
+	if !s3NamespaceExists(w, r, store, namespace) {
+		return
+	}
+
+	query := r.URL.Query()
+	isV2 := query.Get("list-type") == "2"
+
+	maxKeys := s3MaxKeys
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Provided max-keys not an integer or within integer range")
+
+			return
+		}
+
+		maxKeys = min(n, s3MaxKeys)
+	}
+
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid Encoding Method specified in Request")
+
+		return
+	}
+
+	result := s3ListBucketResult{
+		XMLNS:        s3XMLNamespace,
+		Name:         namespace,
+		Prefix:       query.Get("prefix"),
+		Delimiter:    query.Get("delimiter"),
+		MaxKeys:      maxKeys,
+		EncodingType: encodingType,
+	}
+
+	var startAfter string
+
+	if isV2 {
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+
+		startAfter = result.StartAfter
+
+		if result.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+
+				return
+			}
+
+			startAfter = string(token)
+		}
+	} else {
+		marker := query.Get("marker")
+
+		result.Marker = &marker
+		startAfter = marker
+	}
+
+	page, err := listObjectsPage(r, store, namespace, result.Prefix, result.Delimiter, startAfter, maxKeys)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	encode := func(s string) string {
+		if encodingType == "url" {
+			return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+		}
+
+		return s
+	}
+
+	for _, object := range page.objects {
+		result.Contents = append(result.Contents, s3Object{
+			Key:          encode(object.Key),
+			LastModified: object.LastModified.UTC().Format(s3TimeFormat),
+			ETag:         strconv.Quote(object.ETag),
+			Size:         object.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	for _, commonPrefix := range page.commonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{
+			Prefix: encode(commonPrefix),
+		})
+	}
+
+	result.Prefix = encode(result.Prefix)
+	result.Delimiter = encode(result.Delimiter)
+	result.IsTruncated = page.truncated
+
+	if isV2 {
+		keyCount := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &keyCount
+
+		if page.truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(page.next))
+		}
+	} else if page.truncated {
+		result.NextMarker = page.next
+	}
+
+	writeS3XML(w, http.StatusOK, result)
+}
+
+type objectsPage struct {
+	objects        []ObjectInfo
+	commonPrefixes []string
+	truncated      bool
+	next           string // last key (or skipped common prefix) of this page
+}
+
+func listObjectsPage(r *http.Request, store *Store, namespace, prefix, delimiter, startAfter string, maxKeys int) (objectsPage, error) {
+	var page objectsPage
+
+	if maxKeys == 0 {
+		return page, nil
+	}
+
+	objects, truncated, err := store.ListObjects(r.Context(), namespace, prefix, startAfter, maxKeys)
+	if err != nil {
+		return page, err
+	}
+
+	page.truncated = truncated
+
+	seen := make(map[string]struct{})
+
+	for _, object := range objects {
+		if delimiter != "" {
+			rest := strings.TrimPrefix(object.Key, prefix)
+
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				commonPrefix := prefix + rest[:i+len(delimiter)]
+				if _, ok := seen[commonPrefix]; !ok {
+					seen[commonPrefix] = struct{}{}
+					page.commonPrefixes = append(page.commonPrefixes, commonPrefix)
+				}
+
+				page.next = commonPrefix + string(utf8.MaxRune) // sorts after every key under common prefix
+
+				continue
+			}
+		}
+
+		page.objects = append(page.objects, object)
+		page.next = object.Key
+	}
+
+	return page, nil
+}
+
+func HandleS3PutObject(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Server side copy is not implemented")
+
+		return
+	}
+
+	defer r.Body.Close()
+
+	opts := s3PutOptions(r)
+
+	checksums, err := ChecksumsFromRequest(r.Header)
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidDigest", "The Content-MD5 or checksum value that you specified is not valid.")
+
+		return
+	}
+
+	opts.Checksums = checksums
+	opts.Conditions = WriteConditionsFromRequest(r.Header)
+
+	opts.SSEC, err = SSECFromRequest(r.Header)
+	if err == nil {
+		opts.Expires, err = ExpiresFromRequest(r.Header)
+	}
+
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	body := NewChecksumReader(r.Body, checksums, r.ContentLength)
+
+	info, err := store.PutObject(r.Context(), namespace, id, body, r.ContentLength, opts)
+	if body.Err() != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "BadDigest", "The Content-MD5 or checksum value that you specified did not match what the server received.")
+
+		return
+	}
+
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	writeSSECHeaders(w, info.customerKeyMD5)
+	writeVersionHeader(w, info.VersionID)
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("Object %q uploaded to %q", id, info.Backend)
+}
+
+func HandleS3GetObject(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	br, err := ParseByteRange(r.Header.Get("Range"))
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	sse, err := SSECFromRequest(r.Header)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	object, err := store.GetObject(r.Context(), namespace, id, GetOptions{Range: br, SSEC: sse, VersionID: r.URL.Query().Get("versionId")}) // S3 clients expect stored bytes as sent
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+	defer object.Close()
+
+	writeS3ObjectMetadata(w, object.Info)
+	writeObjectHeaders(w, object)
+
+	if _, err := copyObjectVerified(w, object); err != nil {
+		abortObjectResponse(id, err)
+
+		return
+	}
+
+	log.Printf("Object %q fetched from %q", id, object.Info.Backend)
+}
+
+func HandleS3HeadObject(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	sse, err := SSECFromRequest(r.Header)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	info, err := store.StatObject(r.Context(), namespace, id, GetOptions{SSEC: sse, VersionID: r.URL.Query().Get("versionId")})
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	writeS3ObjectMetadata(w, info)
+	writeObjectHeaders(w, &ObjectReader{
+		Info:   info,
+		Length: info.Size,
+	})
+}
+
+func HandleS3DeleteObject(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := store.RemoveObject(r.Context(), namespace, id, WriteConditionsFromRequest(r.Header))
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	log.Printf("Object %q deleted from %q", id, info.Backend)
+}
+
+func HandleS3DeleteObjects(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return
+	}
+
+	defer r.Body.Close()
+
+	var req s3DeleteRequest
+
+	err := xml.NewDecoder(io.LimitReader(r.Body, 2<<20)).Decode(&req)
+	if err != nil || len(req.Objects) == 0 || len(req.Objects) > s3MaxDeleteObjects {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+
+		return
+	}
+
+	result := s3DeleteResult{
+		XMLNS: s3XMLNamespace,
+	}
+
+	for _, object := range req.Objects {
+		switch {
+		case !IsValidID(object.Key):
+			result.Errors = append(result.Errors, s3DeleteError{
+				Key:     object.Key,
+				Code:    "InvalidArgument",
+				Message: InvalidIDMessage(),
+			})
+
+			continue
+		case !Authorized(r, ActionDelete, namespace, object.Key):
+			result.Errors = append(result.Errors, s3DeleteError{
+				Key:     object.Key,
+				Code:    "AccessDenied",
+				Message: "Access Denied",
+			})
+
+			continue
+		}
+
+		info, err := store.RemoveObject(r.Context(), namespace, object.Key, WriteConditions{})
+		if err != nil {
+			result.Errors = append(result.Errors, s3DeleteError{
+				Key:     object.Key,
+				Code:    "InternalError",
+				Message: CapitalizeErrorString(err),
+			})
+
+			continue
+		}
+
+		log.Printf("Object %q deleted from %q", object.Key, info.Backend)
+
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, s3Deleted{Key: object.Key})
+		}
+	}
+
+	writeS3XML(w, http.StatusOK, result)
+}
+
+func getS3Namespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+
+		return "", false
+	}
+
+	return namespace, true
+}
+
+func getS3NamespaceAndID(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	namespace, ok := getS3Namespace(w, r)
+	if !ok {
+		return "", "", false
+	}
+
+	id := GetID(r, w)
+	if id == "" {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", InvalidIDMessage())
+
+		return "", "", false
+	}
+
+	return namespace, id, true
+}
+
+func s3NamespaceExists(w http.ResponseWriter, r *http.Request, store *Store, namespace string) bool {
+	exists, err := store.NamespaceExists(r.Context(), namespace)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return false
+	}
+
+	if !exists {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+
+		return false
+	}
+
+	return true
+}
+
+func s3PutOptions(r *http.Request) PutOptions {
+	userMetadata := make(map[string]string)
+
+	for name, values := range r.Header {
+		if key, ok := strings.CutPrefix(name, s3UserMetaPrefix); ok && len(values) > 0 {
+			userMetadata[key] = values[0]
+		}
+	}
+
+	return PutOptions{
+		ContentType:  r.Header.Get("Content-Type"),
+		UserMetadata: userMetadata,
+	}
+}
+
+func writeS3ObjectMetadata(w http.ResponseWriter, info ObjectInfo) {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	for key, value := range info.UserMetadata {
+		w.Header().Set(s3UserMetaPrefix+key, value)
+	}
+
+	writeExpiresHeader(w, info.Expires)
+}
+
+func writeS3XML(w http.ResponseWriter, status int, v any) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w,
+			CapitalizeErrorString(err),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead { // HEAD responses have no body
+		w.WriteHeader(status)
+
+		return
+	}
+
+	writeS3XML(w, status, s3Error{
+		Code:      code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+func writeS3StoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrObjectNotFound), errors.Is(err, ErrBucketNotFound):
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case errors.Is(err, ErrNamespaceNotFound):
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	case errors.Is(err, ErrNamespaceNotEmpty):
+		writeS3Error(w, r, http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+	case errors.Is(err, ErrNamespaceProtected), errors.Is(err, ErrAccessDenied):
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+	case errors.Is(err, ErrNoSuchUpload):
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+	case errors.Is(err, ErrInvalidPartOrder):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+	case errors.Is(err, ErrInvalidPart):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+	case errors.Is(err, ErrInvalidRange):
+		writeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+	case errors.Is(err, ErrInvalidSSEC):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Requests specifying Server Side Encryption with Customer provided keys must provide a valid encryption algorithm, key and key MD5.")
+	case errors.Is(err, ErrSSECMismatch):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+	case errors.Is(err, ErrSSECInsecure):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest", "Requests specifying Server Side Encryption with Customer provided keys must be made over a secure connection.")
+	case errors.Is(err, ErrSSECNotSupported):
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Server Side Encryption with Customer provided keys is not supported for this bucket or request.")
+	case errors.Is(err, ErrPreconditionFailed):
+		writeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	case errors.Is(err, ErrVersioningDisabled):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid version id specified")
+	case errors.Is(err, ErrInvalidExpires):
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "X-Object-Expires must be an HTTP date or a positive number of seconds")
+	default:
+		log.Printf("S3 API request %s %q failed: %v", r.Method, r.URL.Path, err)
+
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	}
+}
@@ -0,0 +1,168 @@
+package s3gw
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func HandleS3CreateMultipartUpload(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	opts := s3PutOptions(r)
+
+	var err error
+
+	opts.SSEC, err = SSECFromRequest(r.Header)
+	if err == nil {
+		opts.Expires, err = ExpiresFromRequest(r.Header)
+	}
+
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	uploadID, err := store.InitiateMultipartUpload(r.Context(), namespace, id, opts)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	writeS3XML(w, http.StatusOK, s3InitiateMultipartUploadResult{
+		XMLNS:    s3XMLNamespace,
+		Bucket:   namespace,
+		Key:      id,
+		UploadID: uploadID,
+	})
+
+	log.Printf("Multipart upload %q of object %q initiated", uploadID, id)
+}
+
+func HandleS3UploadPart(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	partNumber, ok := ParsePartNumber(query.Get("partNumber"))
+	if !ok {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive")
+
+		return
+	}
+
+	defer r.Body.Close()
+
+	info, err := store.UploadPart(r.Context(), namespace, id, uploadID, partNumber, r.Body, r.ContentLength)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("Part %d of multipart upload %q uploaded to %q", partNumber, uploadID, info.Backend)
+}
+
+func HandleS3CompleteMultipartUpload(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+
+	defer r.Body.Close()
+
+	var req s3CompleteMultipartUpload
+
+	err := xml.NewDecoder(io.LimitReader(r.Body, 2<<20)).Decode(&req)
+	if err != nil || len(req.Parts) == 0 || len(req.Parts) > maxPartNumber {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+
+		return
+	}
+
+	parts := make([]CompletePart, 0, len(req.Parts))
+	for _, part := range req.Parts {
+		parts = append(parts, CompletePart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+	}
+
+	info, err := store.CompleteMultipartUpload(r.Context(), namespace, id, uploadID, parts)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	writeS3XML(w, http.StatusOK, s3CompleteMultipartUploadResult{
+		XMLNS:    s3XMLNamespace,
+		Location: r.URL.Path,
+		Bucket:   namespace,
+		Key:      id,
+		ETag:     strconv.Quote(info.ETag),
+	})
+
+	log.Printf("Multipart upload %q of object %q completed on %q", uploadID, id, info.Backend)
+}
+
+func HandleS3AbortMultipartUpload(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getS3NamespaceAndID(w, r)
+	if !ok {
+		return
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+
+	err := store.AbortMultipartUpload(r.Context(), namespace, id, uploadID)
+	if err != nil {
+		writeS3StoreError(w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	log.Printf("Multipart upload %q of object %q aborted", uploadID, id)
+}
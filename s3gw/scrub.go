@@ -0,0 +1,568 @@
+package s3gw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+const (
+	ScrubIntervalEnvKey   = "SCRUB_INTERVAL"    // 0 disables scheduled runs, admin endpoint still works
+	ScrubRateLimitEnvKey  = "SCRUB_RATE_LIMIT"  // objects checked or fixed per second
+	ScrubAutoFixEnvKey    = "SCRUB_AUTO_FIX"    // move misplaced objects, rebuild shards
+	ScrubVerifyDataEnvKey = "SCRUB_VERIFY_DATA" // read objects back and compare with stored checksums
+)
+
+const (
+	scrubListPageSize  = 1000
+	scrubMaxFindings   = 1000 // findings kept in report, counts are exact
+	scrubFindingDetail = 256
+)
+
+type ScrubFindingKind string
+
+const (
+	ScrubMisplaced        ScrubFindingKind = "misplaced"         // object or part is not on its ring owner
+	ScrubMissingPart      ScrubFindingKind = "missing-part"      // manifest part or erasure shard is gone
+	ScrubChecksumMismatch ScrubFindingKind = "checksum-mismatch" // stored data does not match stored checksum
+	ScrubUnreadable       ScrubFindingKind = "unreadable"
+)
+
+var (
+	scrubObjectsScanned = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "scrub",
+		Name:      "objects_scanned_total",
+		Help:      "Objects checked by scrubber.",
+	})
+	scrubFindings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "scrub",
+		Name:      "findings_total",
+		Help:      "Problems found by scrubber.",
+	}, []string{"kind"})
+	scrubFixes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "scrub",
+		Name:      "fixes_total",
+		Help:      "Problems fixed by scrubber.",
+	}, []string{"kind"})
+	scrubLastFindings = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "scrub",
+		Name:      "last_run_findings",
+		Help:      "Problems found by last finished scrubber run.",
+	}, []string{"kind"})
+	scrubLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "scrub",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Finish time of last scrubber run.",
+	})
+)
+
+type ScrubFinding struct {
+	Kind      ScrubFindingKind `json:"kind"`
+	Namespace string           `json:"namespace"`
+	Key       string           `json:"key"`
+	Backend   string           `json:"backend"`
+	Detail    string           `json:"detail,omitempty"`
+	Fixed     bool             `json:"fixed"`
+}
+
+type ScrubReport struct {
+	Running        bool                     `json:"running"`
+	StartedAt      time.Time                `json:"startedAt"`
+	FinishedAt     time.Time                `json:"finishedAt"`
+	ObjectsScanned int64                    `json:"objectsScanned"`
+	Counts         map[ScrubFindingKind]int `json:"counts"`
+	Findings       []ScrubFinding           `json:"findings"`
+	Error          string                   `json:"error,omitempty"`
+}
+
+type Scrubber struct {
+	store *Store
+
+	limiter    *rate.Limiter
+	autoFix    bool
+	verifyData bool
+
+	trigger chan struct{}
+
+	mu      sync.Mutex
+	last    ScrubReport
+	current *ScrubReport
+}
+
+func NewScrubber(store *Store) *Scrubber {
+	limit := rate.Inf
+	if v := MustGetFloat64FromEnv(ScrubRateLimitEnvKey); v > 0 {
+		limit = rate.Limit(v)
+	}
+
+	autoFix, _ := strconv.ParseBool(os.Getenv(ScrubAutoFixEnvKey))
+	verifyData, _ := strconv.ParseBool(os.Getenv(ScrubVerifyDataEnvKey))
+
+	return &Scrubber{
+		store:      store,
+		limiter:    rate.NewLimiter(limit, 1),
+		autoFix:    autoFix,
+		verifyData: verifyData,
+		trigger:    make(chan struct{}, 1),
+	}
+}
+
+func (sc *Scrubber) Run(ctx context.Context) {
+	var tick <-chan time.Time
+
+	if interval := MustGetDurationFromEnv(ScrubIntervalEnvKey); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-sc.trigger:
+		}
+
+		report := sc.scrub(ctx)
+
+		log.Printf("Scrubber checked %d objects, found %v", report.ObjectsScanned, report.Counts)
+	}
+}
+
+func (sc *Scrubber) Trigger() bool { // false when run is already pending
+	select {
+	case sc.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sc *Scrubber) Report() ScrubReport {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.current != nil {
+		report := *sc.current
+		report.Findings = append([]ScrubFinding(nil), report.Findings...)
+
+		return report
+	}
+
+	return sc.last
+}
+
+func (sc *Scrubber) scrub(ctx context.Context) ScrubReport {
+	sc.mu.Lock()
+	sc.current = &ScrubReport{
+		Running:   true,
+		StartedAt: time.Now().UTC(),
+		Counts:    make(map[ScrubFindingKind]int),
+	}
+	sc.mu.Unlock()
+
+	var errs []error
+
+	for _, bDef := range sc.store.backends.GetMembers() {
+		err := sc.scrubBackend(ctx, bDef)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	report := *sc.current
+	report.Running = false
+	report.FinishedAt = time.Now().UTC()
+
+	if err := errors.Join(errs...); err != nil {
+		report.Error = err.Error()
+	}
+
+	for _, kind := range []ScrubFindingKind{ScrubMisplaced, ScrubMissingPart, ScrubChecksumMismatch, ScrubUnreadable} {
+		scrubLastFindings.WithLabelValues(string(kind)).Set(float64(report.Counts[kind]))
+	}
+
+	scrubLastRun.Set(float64(report.FinishedAt.Unix()))
+
+	sc.last = report
+	sc.current = nil
+
+	return report
+}
+
+func (sc *Scrubber) record(f ScrubFinding) {
+	scrubFindings.WithLabelValues(string(f.Kind)).Inc()
+
+	if f.Fixed {
+		scrubFixes.WithLabelValues(string(f.Kind)).Inc()
+	}
+
+	if len(f.Detail) > scrubFindingDetail {
+		f.Detail = f.Detail[:scrubFindingDetail]
+	}
+
+	log.Printf("Scrubber found %s object %q in namespace %q on %q (fixed: %t): %s",
+		f.Kind, f.Key, f.Namespace, f.Backend, f.Fixed, f.Detail)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.current.Counts[f.Kind]++
+
+	if len(sc.current.Findings) < scrubMaxFindings {
+		sc.current.Findings = append(sc.current.Findings, f)
+	}
+}
+
+func (sc *Scrubber) scrubBackend(ctx context.Context, bDef BackendDef) error {
+	buckets, err := bDef.MinioClient.ListBuckets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list S3 buckets on %q: %w", bDef.Name, err)
+	}
+
+	for _, bucket := range buckets {
+		namespace, ok := sc.store.bucketNamespace(bucket.Name)
+		if !ok {
+			continue
+		}
+
+		startAfter := ""
+
+		for { // paged, buckets may be huge
+			objects, err := ListObjectsInBucket(ctx, bDef.MinioClient, bucket.Name, "", startAfter, scrubListPageSize)
+			if err != nil {
+				return fmt.Errorf("failed to list keys in S3 bucket %q on %q: %w", bucket.Name, bDef.Name, err)
+			}
+
+			for _, object := range objects {
+				err = sc.limiter.Wait(ctx)
+				if err != nil {
+					return err
+				}
+
+				sc.scrubObject(ctx, bDef, namespace, object)
+
+				startAfter = object.Key
+			}
+
+			if len(objects) < scrubListPageSize {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (sc *Scrubber) scrubObject(ctx context.Context, bDef BackendDef, namespace string, object minio.ObjectInfo) {
+	sc.mu.Lock()
+	sc.current.ObjectsScanned++
+	sc.mu.Unlock()
+
+	scrubObjectsScanned.Inc()
+
+	_, owner := sc.store.backends.Locate(RingKey(namespace, object.Key))
+	if owner != bDef.Name {
+		f := ScrubFinding{
+			Kind:      ScrubMisplaced,
+			Namespace: namespace,
+			Key:       object.Key,
+			Backend:   bDef.Name,
+			Detail:    fmt.Sprintf("owned by %q", owner),
+		}
+
+		if sc.autoFix {
+			err := sc.fixMisplacedObject(ctx, bDef, owner, namespace, object.Key)
+			if err != nil {
+				f.Detail = fmt.Sprintf("owned by %q, fix failed: %v", owner, err)
+			}
+
+			f.Fixed = err == nil
+		}
+
+		sc.record(f)
+
+		return // owner copy is checked when its backend is scrubbed
+	}
+
+	info, err := sc.store.statObject(ctx, namespace, object.Key, GetOptions{}) // listings do not always carry metadata
+	if err != nil {
+		if !errors.Is(err, ErrObjectNotFound) && !errors.Is(err, ErrSSECMismatch) { // deleted since listing, customer keys are unknown
+			sc.record(ScrubFinding{Kind: ScrubUnreadable, Namespace: namespace, Key: object.Key, Backend: bDef.Name, Detail: err.Error()})
+		}
+
+		return
+	}
+
+	if info.layout == layoutManifest {
+		sc.scrubManifest(ctx, bDef, namespace, object.Key)
+	}
+
+	if sc.verifyData {
+		sc.verifyObjectData(ctx, bDef, namespace, info)
+	}
+}
+
+func (sc *Scrubber) scrubManifest(ctx context.Context, bDef BackendDef, namespace, id string) {
+	m, err := sc.store.readManifest(ctx, bDef.MinioClient, sc.store.BucketName(namespace), id, "")
+	if err != nil {
+		sc.record(ScrubFinding{Kind: ScrubUnreadable, Namespace: namespace, Key: id, Backend: bDef.Name, Detail: err.Error()})
+
+		return
+	}
+
+	for _, part := range m.Parts {
+		_, err := sc.store.statInternal(ctx, part.RingKey, part.Key)
+		if err == nil {
+			continue
+		}
+
+		f := ScrubFinding{
+			Kind:      ScrubMissingPart,
+			Namespace: namespace,
+			Key:       id,
+			Backend:   bDef.Name,
+			Detail:    fmt.Sprintf("part %q: %v", part.Key, err),
+		}
+
+		if stray, ok := sc.findInternal(ctx, part.Key); ok { // ring changed since part was written
+			f.Kind = ScrubMisplaced
+			f.Detail = fmt.Sprintf("part %q is on %q", part.Key, stray.Name)
+
+			if sc.autoFix {
+				err = sc.fixMisplacedPart(ctx, stray, part)
+				if err != nil {
+					f.Detail = fmt.Sprintf("part %q is on %q, fix failed: %v", part.Key, stray.Name, err)
+				}
+
+				f.Fixed = err == nil
+			}
+		}
+
+		sc.record(f)
+	}
+
+	if m.Erasure == nil {
+		return
+	}
+
+	for i, shard := range m.Erasure.Shards {
+		shardBackend, ok := sc.store.backends.Get(shard.Backend)
+		if ok && !shard.Missing {
+			_, err = sc.store.statInternalAt(ctx, shardBackend, shard.Key)
+		}
+
+		if ok && !shard.Missing && err == nil {
+			continue
+		}
+
+		f := ScrubFinding{
+			Kind:      ScrubMissingPart,
+			Namespace: namespace,
+			Key:       id,
+			Backend:   shard.Backend,
+			Detail:    fmt.Sprintf("shard %d", i),
+		}
+
+		if sc.autoFix { // rebuilds every damaged shard of object at once
+			repaired, err := sc.store.repairErasureObject(ctx, namespace, id)
+			if err != nil {
+				f.Detail = fmt.Sprintf("shard %d, fix failed: %v", i, err)
+			}
+
+			f.Fixed = repaired
+		}
+
+		sc.record(f)
+
+		return
+	}
+}
+
+func (sc *Scrubber) verifyObjectData(ctx context.Context, bDef BackendDef, namespace string, info ObjectInfo) {
+	expected, ok := expectedReadChecksums(info)
+	if !ok {
+		return
+	}
+
+	object, err := sc.store.getObject(ctx, namespace, info.Key, info, GetOptions{}) // bypasses read cache
+	if err != nil {
+		sc.record(ScrubFinding{Kind: ScrubUnreadable, Namespace: namespace, Key: info.Key, Backend: bDef.Name, Detail: err.Error()})
+
+		return
+	}
+	defer object.Close()
+
+	hashes := newChecksumHashes(expected)
+
+	_, err = io.Copy(hashes, object)
+	if err != nil {
+		sc.record(ScrubFinding{Kind: ScrubUnreadable, Namespace: namespace, Key: info.Key, Backend: bDef.Name, Detail: err.Error()})
+
+		return
+	}
+
+	if !hashes.verify() {
+		sc.record(ScrubFinding{Kind: ScrubChecksumMismatch, Namespace: namespace, Key: info.Key, Backend: bDef.Name})
+	}
+}
+
+func (sc *Scrubber) findInternal(ctx context.Context, key string) (BackendDef, bool) {
+	for _, bDef := range sc.store.backends.GetMembers() {
+		_, err := sc.store.statInternalAt(ctx, bDef, key)
+		if err == nil {
+			return bDef, true
+		}
+	}
+
+	return BackendDef{}, false
+}
+
+func (sc *Scrubber) fixMisplacedObject(ctx context.Context, stray BackendDef, owner, namespace, id string) error {
+	ownerDef, ok := sc.store.backends.Get(owner)
+	if !ok {
+		return ErrNoBackend
+	}
+
+	bucketName := sc.store.BucketName(namespace)
+
+	defer sc.store.lockObject(namespace, id)() // owner ETag and age are read under key lock, foreground PUT is never overwritten by stale stray
+
+	defer sc.store.invalidateCached(namespace, id) // newer stray replaces owner copy
+
+	strayInfo, err := stray.MinioClient.StatObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	ownerInfo, err := ownerDef.MinioClient.StatObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	ownerMissing := minio.ToErrorResponse(err).Code == "NoSuchKey" || minio.ToErrorResponse(err).Code == "NoSuchBucket"
+
+	if err != nil && !ownerMissing {
+		return err
+	}
+
+	versioned := sc.store.namespaces.For(namespace).Versioning // noncurrent versions may still reference parts
+
+	if ownerMissing || ownerInfo.LastModified.Before(strayInfo.LastModified) { // newer copy wins
+		var previous *Manifest
+		if !ownerMissing && !versioned {
+			previous = sc.store.previousManifest(ctx, ownerDef.MinioClient, bucketName, id)
+		}
+
+		_, err = CopyObjectAcrossBackends(ctx, stray.MinioClient, ownerDef.MinioClient, bucketName, id, bucketName, id)
+		if err != nil {
+			return err
+		}
+
+		sc.store.releaseManifest(ctx, previous)
+	} else if ownerInfo.ETag != strayInfo.ETag && !versioned { // stale stray, parts it references are no longer used
+		sc.store.releaseManifest(ctx, sc.store.previousManifest(ctx, stray.MinioClient, bucketName, id))
+	}
+
+	if !versioned {
+		return stray.MinioClient.RemoveObject(ctx, bucketName, id, minio.RemoveObjectOptions{})
+	}
+
+	return removeObjectVersions(ctx, stray.MinioClient, bucketName, id) // plain delete would only add delete marker
+}
+
+func removeObjectVersions(ctx context.Context, client *minio.Client, bucketName, id string) error {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop listing once keys sorting after id are reached
+
+	versionIDs := make([]string, 0)
+
+	objectCh := client.ListObjects(listCtx, bucketName, minio.ListObjectsOptions{
+		Prefix:       id,
+		Recursive:    true,
+		WithVersions: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		if object.Key > id {
+			break
+		}
+
+		if object.Key == id {
+			versionIDs = append(versionIDs, object.VersionID)
+		}
+	}
+
+	for _, versionID := range versionIDs {
+		err := client.RemoveObject(ctx, bucketName, id, minio.RemoveObjectOptions{VersionID: versionID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sc *Scrubber) fixMisplacedPart(ctx context.Context, stray BackendDef, part ManifestPart) error {
+	client, _ := sc.store.backends.Locate(part.RingKey)
+	if client == nil {
+		return ErrNoBackend
+	}
+
+	_, err := CopyObjectAcrossBackends(ctx, stray.MinioClient, client,
+		sc.store.internalBucketName, part.Key, sc.store.internalBucketName, part.Key)
+	if err != nil {
+		return err
+	}
+
+	return sc.store.removeInternalAt(ctx, stray, part.Key)
+}
+
+func HandleScrubReport(w http.ResponseWriter, r *http.Request, scrubber *Scrubber) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(scrubber.Report())
+	if err != nil {
+		log.Printf("Failed to write scrub report: %v", err)
+	}
+}
+
+func HandleScrubStart(w http.ResponseWriter, r *http.Request, scrubber *Scrubber) {
+	if !scrubber.Trigger() {
+		http.Error(w,
+			"Scrub run is already pending",
+			http.StatusConflict,
+		)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	log.Printf("Scrub run requested")
+}
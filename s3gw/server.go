@@ -0,0 +1,63 @@
+package s3gw
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	HTTPListenAddressEnvKey     = "HTTP_LISTEN_ADDRESS"
+	HTTPReadHeaderTimeoutEnvKey = "HTTP_READ_HEADER_TIMEOUT"
+	HTTPReadTimeoutEnvKey       = "HTTP_READ_TIMEOUT"
+	HTTPWriteTimeoutEnvKey      = "HTTP_WRITE_TIMEOUT"
+	HTTPIdleTimeoutEnvKey       = "HTTP_IDLE_TIMEOUT"
+	HTTPShutdownTimeoutEnvKey   = "HTTP_SHUTDOWN_TIMEOUT"
+)
+
+func NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: handler,
+
+		ReadHeaderTimeout: MustGetDurationFromEnv(HTTPReadHeaderTimeoutEnvKey),
+		ReadTimeout:       MustGetDurationFromEnv(HTTPReadTimeoutEnvKey), // covers whole request body, keep it large enough for uploads
+		WriteTimeout:      MustGetDurationFromEnv(HTTPWriteTimeoutEnvKey),
+		IdleTimeout:       MustGetDurationFromEnv(HTTPIdleTimeoutEnvKey),
+	}
+}
+
+// Background tracks long-running workers (watchers, rebalancers, etc.) so they can be stopped on shutdown.
+type Background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg sync.WaitGroup
+}
+
+func NewBackground() *Background {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Background{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (b *Background) Go(name string, fn func(ctx context.Context)) {
+	b.wg.Add(1)
+
+	go func() {
+		defer b.wg.Done()
+
+		log.Printf("Background worker %q started", name)
+		fn(b.ctx)
+		log.Printf("Background worker %q stopped", name)
+	}()
+}
+
+func (b *Background) Stop() {
+	b.cancel()
+	b.wg.Wait()
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/buraksezer/consistent"
@@ -14,10 +15,22 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+const (
+	S3BackendTLSEnvKey        = "S3_BACKEND_TLS"
+	S3BackendCAFileEnvKey     = "S3_BACKEND_CA_FILE" // added to system roots
+	S3BackendServerNameEnvKey = "S3_BACKEND_SERVER_NAME"
+)
+
 func Configure(ctx context.Context) (*Backends, error) {
 	backendsConfig := new(Backends)
 
 	backendsConfig.backends = make(map[string]*minio.Client)
+	backendsConfig.secure, _ = strconv.ParseBool(os.Getenv(S3BackendTLSEnvKey))
+
+	tlsConfig, err := backendTLSConfig(backendsConfig.secure)
+	if err != nil {
+		return nil, err
+	}
 
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -62,6 +75,17 @@ func Configure(ctx context.Context) (*Backends, error) {
 			return nil, fmt.Errorf("failed to get S3 backend addresses for %s", backend)
 		}
 
+		transport, err := minio.DefaultTransport(backendsConfig.secure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 backend transport for %s: %w", backend, err)
+		}
+
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig.Clone()
+		}
+
+		backendsConfig.transports = append(backendsConfig.transports, transport)
+
 		isAlive := false
 		cfg := MustCreateNewS3BackendConfig(
 			net.JoinHostPort(
@@ -69,13 +93,18 @@ func Configure(ctx context.Context) (*Backends, error) {
 				os.Getenv(S3APIPortEnvKey),
 			),
 			&minio.Options{
-				Creds:  credentials.NewStaticV4(user, password, ""),
-				Secure: false, // assuming no secure config is set (internal network)
+				Creds:     credentials.NewStaticV4(user, password, ""),
+				Secure:    backendsConfig.secure,
+				Transport: transport,
 			},
 		)
 
 		for i := 1; i <= 5; i++ { // wait for backend to be alive or hard fail
-			time.Sleep(MustParseDuration(fmt.Sprintf("%ds", i)))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(MustParseDuration(fmt.Sprintf("%ds", i))):
+			}
 
 			err = CheckS3BackendLiveliness(ctx, cfg)
 			if err == nil {
@@ -0,0 +1,404 @@
+package s3gw
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+const (
+	sigV4Algorithm         = "AWS4-HMAC-SHA256"
+	sigV4ChunkAlgorithm    = "AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4TimeFormat        = "20060102T150405Z"
+	sigV4DateFormat        = "20060102"
+	sigV4UnsignedPayload   = "UNSIGNED-PAYLOAD"
+	sigV4StreamingPayload  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4EmptySHA256       = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	sigV4MaxPresignExpires = 7 * 24 * time.Hour
+	sigV4MaxChunkSize      = 16 << 20
+)
+
+var (
+	errUnsupportedPayload = errors.New("unsupported payload signing mode")
+	errPayloadMismatch    = errors.New("payload does not match signed content hash")
+	errChunkSignature     = errors.New("invalid chunk signature")
+)
+
+type sigV4Credential struct {
+	accessKeyID string
+	date        string
+	region      string
+	service     string
+}
+
+func (c sigV4Credential) scope() string {
+	return strings.Join([]string{c.date, c.region, c.service, "aws4_request"}, "/")
+}
+
+func parseSigV4Credential(s string) (sigV4Credential, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return sigV4Credential{}, errInvalidCredentials
+	}
+
+	return sigV4Credential{
+		accessKeyID: parts[0],
+		date:        parts[1],
+		region:      parts[2],
+		service:     parts[3],
+	}, nil
+}
+
+func parseSigV4SignedHeaders(s string) ([]string, error) {
+	signedHeaders := strings.Split(s, ";")
+	if !slices.Contains(signedHeaders, "host") { // unsigned host would let signature be replayed against other endpoints
+		return nil, errInvalidCredentials
+	}
+
+	return signedHeaders, nil
+}
+
+func IsSigV4Request(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), sigV4Algorithm) ||
+		r.URL.Query().Get("X-Amz-Algorithm") == sigV4Algorithm
+}
+
+func (a *Authenticator) authenticateSigV4(r *http.Request) (*AuthKey, error) {
+	if r.URL.Query().Has("X-Amz-Signature") {
+		return a.authenticateSigV4Presigned(r)
+	}
+
+	fields := make(map[string]string)
+
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), sigV4Algorithm), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, errInvalidCredentials
+		}
+
+		fields[kv[0]] = kv[1]
+	}
+
+	cred, err := parseSigV4Credential(fields["Credential"])
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := a.byID[cred.accessKeyID]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil || t.Format(sigV4DateFormat) != cred.date {
+		return nil, errInvalidCredentials
+	}
+
+	if !a.withinClockSkew(t) {
+		return nil, errRequestExpired
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = sigV4UnsignedPayload
+	}
+
+	signedHeaders, err := parseSigV4SignedHeaders(fields["SignedHeaders"])
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+
+	signingKey := sigV4SigningKey(key.Secret, cred)
+	signature := sigV4Signature(signingKey, amzDate, cred,
+		sigV4CanonicalRequest(r, query, signedHeaders, payloadHash))
+
+	if !hmac.Equal([]byte(signature), []byte(fields["Signature"])) {
+		return nil, errInvalidCredentials
+	}
+
+	switch {
+	case payloadHash == sigV4UnsignedPayload:
+	case payloadHash == sigV4StreamingPayload:
+		decodedLength, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			return nil, errInvalidCredentials
+		}
+
+		r.Body = &sigV4ChunkedReader{
+			body:          r.Body,
+			r:             bufio.NewReader(r.Body),
+			signingKey:    signingKey,
+			amzDate:       amzDate,
+			cred:          cred,
+			prevSignature: signature,
+		}
+		r.ContentLength = decodedLength
+		r.Header.Del("Content-Encoding") // "aws-chunked" is consumed here
+	case len(payloadHash) == sha256.Size*2:
+		expected, err := hex.DecodeString(payloadHash)
+		if err != nil {
+			return nil, errInvalidCredentials
+		}
+
+		r.Body = &sigV4HashingReader{
+			body:     r.Body,
+			hash:     sha256.New(),
+			expected: expected,
+			size:     r.ContentLength,
+		}
+	default:
+		return nil, errUnsupportedPayload
+	}
+
+	return key, nil
+}
+
+func (a *Authenticator) authenticateSigV4Presigned(r *http.Request) (*AuthKey, error) {
+	query := r.URL.Query()
+
+	cred, err := parseSigV4Credential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := a.byID[cred.accessKeyID]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+
+	amzDate := query.Get("X-Amz-Date")
+
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil || t.Format(sigV4DateFormat) != cred.date {
+		return nil, errInvalidCredentials
+	}
+
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > sigV4MaxPresignExpires {
+		return nil, errInvalidCredentials
+	}
+
+	now := time.Now()
+	if t.After(now.Add(a.maxClockSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+		return nil, errRequestExpired
+	}
+
+	signature := query.Get("X-Amz-Signature")
+	query.Del("X-Amz-Signature")
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = sigV4UnsignedPayload
+	}
+
+	signedHeaders, err := parseSigV4SignedHeaders(query.Get("X-Amz-SignedHeaders"))
+	if err != nil {
+		return nil, err
+	}
+
+	expected := sigV4Signature(sigV4SigningKey(key.Secret, cred), amzDate, cred,
+		sigV4CanonicalRequest(r, query, signedHeaders, payloadHash))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, errInvalidCredentials
+	}
+
+	return key, nil
+}
+
+func sigV4CanonicalRequest(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	sort.Strings(signedHeaders)
+
+	var headers bytes.Buffer
+
+	for _, name := range signedHeaders {
+		var value string
+
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		s3utils.EncodePath(r.URL.Path),
+		strings.ReplaceAll(query.Encode(), "+", "%20"),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func sigV4SigningKey(secret string, cred sigV4Credential) []byte {
+	key := sumHMAC([]byte("AWS4"+secret), []byte(cred.date))
+	key = sumHMAC(key, []byte(cred.region))
+	key = sumHMAC(key, []byte(cred.service))
+
+	return sumHMAC(key, []byte("aws4_request"))
+}
+
+func sigV4Signature(signingKey []byte, amzDate string, cred sigV4Credential, canonicalRequest string) string {
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		cred.scope(),
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	return hex.EncodeToString(sumHMAC(signingKey, []byte(stringToSign)))
+}
+
+func sumHMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+type sigV4HashingReader struct { // verifies signed payload hash once declared length or EOF is reached
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+	size     int64 // declared length, -1 when unknown
+	read     int64
+	err      error
+}
+
+func (h *sigV4HashingReader) Read(p []byte) (int, error) {
+	if h.err != nil {
+		return 0, h.err
+	}
+
+	n, err := h.body.Read(p)
+	h.hash.Write(p[:n])
+	h.read += int64(n)
+
+	if (errors.Is(err, io.EOF) || h.read == h.size) && !hmac.Equal(h.hash.Sum(nil), h.expected) {
+		h.err = errPayloadMismatch
+
+		return 0, h.err // last bytes are withheld, chunked uploads stop reading at declared length
+	}
+
+	return n, err
+}
+
+func (h *sigV4HashingReader) Close() error {
+	return h.body.Close()
+}
+
+type sigV4ChunkedReader struct { // decodes "aws-chunked" body verifying every chunk signature
+	body io.Closer
+	r    *bufio.Reader
+
+	signingKey    []byte
+	amzDate       string
+	cred          sigV4Credential
+	prevSignature string
+
+	chunk []byte
+	pos   int
+	done  bool
+	err   error
+}
+
+func (c *sigV4ChunkedReader) Read(p []byte) (int, error) {
+	for c.pos >= len(c.chunk) {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		if c.done {
+			return 0, io.EOF
+		}
+
+		c.err = c.readChunk()
+	}
+
+	n := copy(p, c.chunk[c.pos:])
+	c.pos += n
+
+	return n, nil
+}
+
+func (c *sigV4ChunkedReader) readChunk() error {
+	header, err := c.r.ReadString('\n')
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	sizeHex, signature, ok := strings.Cut(strings.TrimRight(header, "\r\n"), ";chunk-signature=")
+	if !ok {
+		return fmt.Errorf("malformed chunk header: %w", errChunkSignature)
+	}
+
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > sigV4MaxChunkSize {
+		return fmt.Errorf("malformed chunk size: %w", errChunkSignature)
+	}
+
+	c.chunk = make([]byte, size)
+	c.pos = 0
+
+	if _, err = io.ReadFull(c.r, c.chunk); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	crlf := make([]byte, 2)
+	if _, err = io.ReadFull(c.r, crlf); err != nil || string(crlf) != "\r\n" {
+		return io.ErrUnexpectedEOF
+	}
+
+	chunkHash := sha256.Sum256(c.chunk)
+	stringToSign := strings.Join([]string{
+		sigV4ChunkAlgorithm,
+		c.amzDate,
+		c.cred.scope(),
+		c.prevSignature,
+		sigV4EmptySHA256,
+		hex.EncodeToString(chunkHash[:]),
+	}, "\n")
+
+	expected := hex.EncodeToString(sumHMAC(c.signingKey, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errChunkSignature
+	}
+
+	c.prevSignature = signature
+
+	if size == 0 {
+		c.done = true
+	}
+
+	return nil
+}
+
+func (c *sigV4ChunkedReader) Close() error {
+	return c.body.Close()
+}
@@ -0,0 +1,270 @@
+package s3gw
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+const (
+	testAccessKey = "gateway-test"
+	testSecretKey = "gateway-test-secret"
+	testRegion    = "us-east-1"
+	testURL       = "http://gateway.local:3000/object/dir/obj.txt"
+)
+
+type sha256Hasher struct {
+	hash.Hash
+}
+
+func (sha256Hasher) Close() {}
+
+func newTestAuthenticator() *Authenticator {
+	key := &AuthKey{
+		ID:     testAccessKey,
+		Secret: testSecretKey,
+		Policies: []AuthPolicy{
+			{
+				Actions:    []Action{ActionRead, ActionWrite},
+				Namespaces: []string{"*"},
+				Prefixes:   []string{"dir/"},
+			},
+		},
+	}
+
+	return &Authenticator{
+		byID:         map[string]*AuthKey{key.ID: key},
+		bySecret:     map[[sha256.Size]byte]*AuthKey{sha256.Sum256([]byte(key.Secret)): key},
+		maxClockSkew: 5 * time.Minute,
+	}
+}
+
+func newTestRouter(t *testing.T, gotBody *[]byte) http.Handler {
+	t.Helper()
+
+	r := mux.NewRouter().UseEncodedPath()
+	r.Use(newTestAuthenticator().Middleware)
+
+	r.HandleFunc("/object/{id:.+}", func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+
+		_, err := io.ReadFull(r.Body, body) // stops at declared length like chunked uploads, body never returns EOF
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		*gotBody = body
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return r
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	if req.Body == nil { // client requests may have no body, server ones always do
+		req.Body = http.NoBody
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestSigV4HeaderAuthentication(t *testing.T) {
+	var body []byte
+
+	h := newTestRouter(t, &body)
+
+	t.Run("Valid signature", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, testURL, nil)
+		req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedPayload)
+
+		rec := serve(h, signer.SignV4(*req, testAccessKey, testSecretKey, "", testRegion))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, testURL, nil)
+		req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedPayload)
+
+		rec := serve(h, signer.SignV4(*req, testAccessKey, "wrong", "", testRegion))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Tampered path", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, testURL, nil)
+		req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedPayload)
+
+		signed := signer.SignV4(*req, testAccessKey, testSecretKey, "", testRegion)
+		signed.URL.Path = "/object/dir/other.txt"
+
+		rec := serve(h, signed)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Host not signed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, testURL, nil)
+		req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedPayload)
+
+		amzDate := time.Now().UTC().Format(sigV4TimeFormat)
+		req.Header.Set("X-Amz-Date", amzDate)
+
+		cred := sigV4Credential{accessKeyID: testAccessKey, date: amzDate[:8], region: testRegion, service: "s3"}
+		signedHeaders := []string{"x-amz-content-sha256", "x-amz-date"}
+		signature := sigV4Signature(sigV4SigningKey(testSecretKey, cred), amzDate, cred,
+			sigV4CanonicalRequest(req, req.URL.Query(), signedHeaders, sigV4UnsignedPayload)) // valid apart from missing host
+
+		req.Header.Set("Authorization", sigV4Algorithm+" Credential="+testAccessKey+"/"+cred.date+"/"+testRegion+"/s3/aws4_request, "+
+			"SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+
+		rec := serve(h, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Policy denies key prefix", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "http://gateway.local:3000/object/elsewhere", nil)
+		req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedPayload)
+
+		rec := serve(h, signer.SignV4(*req, testAccessKey, testSecretKey, "", testRegion))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("Signed payload hash", func(t *testing.T) {
+		payload := []byte("signed payload")
+		sum := sha256.Sum256(payload)
+
+		req, _ := http.NewRequest(http.MethodPut, testURL, bytes.NewReader(payload))
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+
+		signed := signer.SignV4(*req, testAccessKey, testSecretKey, "", testRegion)
+
+		rec := serve(h, signed)
+		if rec.Code != http.StatusOK || !bytes.Equal(body, payload) {
+			t.Errorf("Expected status %d with body %q, got %d with %q", http.StatusOK, payload, rec.Code, body)
+		}
+
+		signed = signer.SignV4(*req, testAccessKey, testSecretKey, "", testRegion)
+		signed.Body = io.NopCloser(strings.NewReader("forged payload"))
+
+		rec = serve(h, signed)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for forged payload, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
+
+func TestSigV4HashingReaderVerifiesDeclaredLength(t *testing.T) {
+	signed, forged := []byte("signed payload"), []byte("forged payload")
+	sum := sha256.Sum256(signed)
+
+	for _, tc := range []struct {
+		body     []byte
+		expected error
+	}{
+		{signed, nil},
+		{forged, errPayloadMismatch},
+	} {
+		h := &sigV4HashingReader{
+			body:     io.NopCloser(bytes.NewReader(tc.body)),
+			hash:     sha256.New(),
+			expected: sum[:],
+			size:     int64(len(tc.body)),
+		}
+
+		n, err := io.ReadFull(h, make([]byte, len(tc.body)))
+		if err != tc.expected {
+			t.Errorf("Expected error %v reading %q to declared length, got %v", tc.expected, tc.body, err)
+		}
+
+		if tc.expected != nil && n == len(tc.body) {
+			t.Errorf("Expected last bytes of %q to be withheld, got all %d", tc.body, n)
+		}
+	}
+}
+
+func TestSigV4PresignedAuthentication(t *testing.T) {
+	var body []byte
+
+	h := newTestRouter(t, &body)
+
+	req, _ := http.NewRequest(http.MethodGet, testURL, nil)
+
+	presigned := signer.PreSignV4(*req, testAccessKey, testSecretKey, "", testRegion, 60)
+
+	rec := serve(h, presigned)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	tampered, _ := http.NewRequest(http.MethodGet, strings.Replace(presigned.URL.String(), "X-Amz-Expires=60", "X-Amz-Expires=600", 1), nil)
+
+	rec = serve(h, tampered)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for tampered expiry, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestSigV4StreamingAuthentication(t *testing.T) {
+	var body []byte
+
+	h := newTestRouter(t, &body)
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 10000) // spans multiple 64KiB chunks
+
+	t.Run("Valid chunks", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPut, testURL, bytes.NewReader(payload))
+
+		signed := signer.StreamingSignV4(req, testAccessKey, testSecretKey, "", testRegion,
+			int64(len(payload)), time.Now().UTC(), sha256Hasher{sha256.New()})
+
+		rec := serve(h, signed)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		if !bytes.Equal(body, payload) {
+			t.Errorf("Expected decoded body of %d bytes, got %d bytes", len(payload), len(body))
+		}
+	})
+
+	t.Run("Tampered chunk", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPut, testURL, bytes.NewReader(payload))
+
+		signed := signer.StreamingSignV4(req, testAccessKey, testSecretKey, "", testRegion,
+			int64(len(payload)), time.Now().UTC(), sha256Hasher{sha256.New()})
+
+		encoded, _ := io.ReadAll(signed.Body)
+		encoded[len(encoded)/2] ^= 0xff
+
+		signed.Body = io.NopCloser(bytes.NewReader(encoded))
+
+		rec := serve(h, signed)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for tampered chunk, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
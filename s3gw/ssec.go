@@ -0,0 +1,89 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+const (
+	headerSSECAlgorithm = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	headerSSECKey       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	headerSSECKeyMD5    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+const ssecAlgorithm = "AES256"
+
+var (
+	ErrInvalidSSEC      = errors.New("customer key headers must carry AES256 algorithm, base64 encoded 256 bit key and its MD5")
+	ErrSSECMismatch     = errors.New("object is stored with customer key, matching key headers must be sent to access it") // also sent for plaintext objects
+	ErrSSECNotSupported = errors.New("customer keys are not supported for this namespace or request")
+	ErrSSECInsecure     = errors.New("customer keys require TLS connections to S3 backends")
+)
+
+func SSECFromRequest(h http.Header) (encrypt.ServerSide, error) { // nil when no customer key was sent
+	algorithm, key, keyMD5 := h.Get(headerSSECAlgorithm), h.Get(headerSSECKey), h.Get(headerSSECKeyMD5)
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return nil, nil
+	}
+
+	if algorithm != ssecAlgorithm {
+		return nil, ErrInvalidSSEC
+	}
+
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, ErrInvalidSSEC
+	}
+
+	sum := md5.Sum(rawKey)
+	if keyMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, ErrInvalidSSEC
+	}
+
+	sse, err := encrypt.NewSSEC(rawKey) // checks key length
+	if err != nil {
+		return nil, ErrInvalidSSEC
+	}
+
+	return sse, nil
+}
+
+func (s *Store) checkSSEC(namespace string, sse encrypt.ServerSide) error {
+	if sse == nil {
+		return nil
+	}
+
+	if !s.backends.secure {
+		return ErrSSECInsecure
+	}
+
+	if nc := s.namespaces.For(namespace); nc.Storage == StorageErasure || nc.Storage == StorageDedup { // shards and blobs are gateway owned objects
+		return ErrSSECNotSupported
+	}
+
+	return nil
+}
+
+func ssecKeyMD5(sse encrypt.ServerSide) string {
+	if sse == nil {
+		return ""
+	}
+
+	h := make(http.Header)
+	sse.Marshal(h)
+
+	return h.Get(headerSSECKeyMD5)
+}
+
+func writeSSECHeaders(w http.ResponseWriter, keyMD5 string) {
+	if keyMD5 == "" {
+		return
+	}
+
+	w.Header().Set(headerSSECAlgorithm, ssecAlgorithm)
+	w.Header().Set(headerSSECKeyMD5, keyMD5)
+}
@@ -0,0 +1,60 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSSECFromRequest(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	sum := md5.Sum(key)
+	keyB64, md5B64 := base64.StdEncoding.EncodeToString(key), base64.StdEncoding.EncodeToString(sum[:])
+
+	short := base64.StdEncoding.EncodeToString(key[:16])
+	shortSum := md5.Sum(key[:16])
+
+	for name, tc := range map[string]struct {
+		algorithm, key, keyMD5 string
+		ok                     bool
+	}{
+		"valid":          {ssecAlgorithm, keyB64, md5B64, true},
+		"wrong md5":      {ssecAlgorithm, keyB64, base64.StdEncoding.EncodeToString(sum[:8]), false},
+		"missing md5":    {ssecAlgorithm, keyB64, "", false},
+		"missing key":    {ssecAlgorithm, "", md5B64, false},
+		"wrong algo":     {"aws:kms", keyB64, md5B64, false},
+		"short key":      {ssecAlgorithm, short, base64.StdEncoding.EncodeToString(shortSum[:]), false},
+		"invalid base64": {ssecAlgorithm, "not base64!", md5B64, false},
+	} {
+		h := make(http.Header)
+		h.Set(headerSSECAlgorithm, tc.algorithm)
+		h.Set(headerSSECKey, tc.key)
+		h.Set(headerSSECKeyMD5, tc.keyMD5)
+
+		sse, err := SSECFromRequest(h)
+		if tc.ok {
+			if err != nil || sse == nil {
+				t.Errorf("Expected %s headers to be accepted, got %v", name, err)
+			} else if got := ssecKeyMD5(sse); got != md5B64 {
+				t.Errorf("Expected key MD5 %q, got %q", md5B64, got)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, ErrInvalidSSEC) {
+			t.Errorf("Expected %s headers to be rejected, got %v", name, err)
+		}
+	}
+
+	sse, err := SSECFromRequest(make(http.Header))
+	if sse != nil || err != nil {
+		t.Errorf("Expected no customer key without headers, got %v", err)
+	}
+}
@@ -0,0 +1,1242 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+var (
+	ErrNoBackend           = errors.New("failed to find S3 backend ID")
+	ErrBucketNotFound      = errors.New("bucket not found")
+	ErrObjectNotFound      = errors.New("object not found")
+	ErrAccessDenied        = errors.New("access denied")
+	ErrNamespaceNotFound   = errors.New("namespace not found")
+	ErrNamespaceNotEmpty   = errors.New("namespace is not empty")
+	ErrNamespaceProtected  = errors.New("default namespace can not be deleted")
+	ErrListBackendsFailure = errors.New("failed to list S3 backend IDs")
+)
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	UserMetadata map[string]string
+	Checksums    Checksums
+	VersionID    string            // empty unless namespace is versioned
+	Expires      time.Time         // zero when object never expires
+	Tags         map[string]string // only filled by listings, tags are not part of object metadata
+
+	Backend string // container ID of backend that served the request
+
+	layout      string
+	headETag    string    // ETag of stored head object, differs from logical ETag of manifest objects
+	compression string    // algorithm of stored bytes, Size is still the original size
+	envelope    *envelope // wrapped data key, nil for plaintext objects
+	headCopy    bool      // head was read from manifest copy, primary owner is unavailable
+
+	customerKeyMD5 string // object is stored with customer key (SSE-C), never cached
+}
+
+type ObjectReader struct {
+	io.ReadCloser
+
+	Info ObjectInfo
+
+	Offset int64
+	Length int64
+
+	ContentEncoding string // stored compressed bytes are passed through, Length is their size
+}
+
+func (o *ObjectReader) whole() bool { // body is exactly the original object
+	return o.Offset == 0 && o.Length == o.Info.Size && o.ContentEncoding == ""
+}
+
+type GetOptions struct {
+	Range          *ByteRange
+	AcceptEncoding string // compressed objects are passed through when their algorithm is accepted
+	SSEC           encrypt.ServerSide
+	VersionID      string // noncurrent versions are read from backend, never cached
+}
+
+func (o GetOptions) backendOptions() minio.GetObjectOptions { // range is set by caller, it may differ from requested one
+	return minio.GetObjectOptions{ServerSideEncryption: o.SSEC, VersionID: o.VersionID}
+}
+
+type PutOptions struct {
+	ContentType  string
+	UserMetadata map[string]string
+	Checksums    Checksums          // verified by caller while streaming, stored as metadata
+	SSEC         encrypt.ServerSide // object is stored as single backend object, key is never kept
+	Conditions   WriteConditions
+	Expires      time.Time // zero leaves expiry to namespace rules
+}
+
+const internalPartSize = 16 << 20
+
+type Store struct { // shared object layer for all API frontends
+	backends           *Backends
+	defaultBucketName  string
+	internalBucketName string // multipart state, parts, etc.
+
+	namespaces *NamespaceConfigs
+	cache      *objectCache
+	disk       *DiskCache
+	buckets    *bucketCache
+	keys       *KeyManager
+	locks      *keyLocks
+
+	chunkThreshold int64
+	chunkSize      int64
+	chunkPrefetch  int
+}
+
+func NewStore(backends *Backends, defaultBucketName string, namespaces *NamespaceConfigs, disk *DiskCache, keys *KeyManager) *Store {
+	return &Store{
+		backends:           backends,
+		defaultBucketName:  defaultBucketName,
+		namespaces:         namespaces,
+		cache:              newObjectCache(),
+		disk:               disk,
+		buckets:            newBucketCache(),
+		keys:               keys,
+		locks:              newKeyLocks(),
+		internalBucketName: os.Getenv(S3InternalBucketNameEnvKey),
+		chunkThreshold:     int64(MustGetIntFromEnv(ChunkedStorageThresholdEnvKey)),
+		chunkSize:          int64(MustGetIntFromEnv(ChunkSizeEnvKey)),
+		chunkPrefetch:      MustGetIntFromEnv(ChunkPrefetchEnvKey),
+	}
+}
+
+func (s *Store) BucketName(namespace string) string {
+	return NamespaceBucketName(namespace, s.defaultBucketName)
+}
+
+func (s *Store) locate(namespace, id string) (*minio.Client, string, error) {
+	client, backendID := s.backends.Locate(RingKey(namespace, id))
+	if client == nil {
+		return nil, "", ErrNoBackend
+	}
+
+	return client, backendID, nil
+}
+
+func (s *Store) PutObject(ctx context.Context, namespace, id string, body io.Reader, size int64, opts PutOptions) (ObjectInfo, error) {
+	err := s.checkSSEC(namespace, opts.SSEC)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	_, _, err = s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.lockObject(namespace, id)() // conditions hold until upload replaced object
+
+	err = s.checkConditions(ctx, namespace, id, opts.Conditions, opts.SSEC)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return s.putObject(ctx, namespace, id, body, size, opts)
+}
+
+func (s *Store) putObject(ctx context.Context, namespace, id string, body io.Reader, size int64, opts PutOptions) (ObjectInfo, error) { // caller holds key lock
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	opts.UserMetadata = withExpires(opts.UserMetadata, opts.Expires)
+
+	defer s.invalidateCached(namespace, id) // also on failure, upload may have replaced object partially
+
+	bucketName := s.BucketName(namespace)
+
+	err = s.ensureBucket(ctx, BackendDef{MinioClient: client, Name: backendID}, bucketName)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to ensure S3 bucket %q existance on %q: %w",
+			bucketName, backendID, err)
+	}
+
+	previous := s.overwrittenManifest(ctx, client, namespace, id)
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if nc := s.namespaces.For(namespace); nc.Storage == StorageErasure {
+		info, m, err := s.putErasure(ctx, client, bucketName, namespace, id, body, size, opts, nc)
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to upload object %q to %q: %w",
+				id, backendID, s.checkBucketError(backendID, bucketName, err))
+		}
+
+		s.releaseManifest(ctx, previous)
+
+		return ObjectInfo{
+			Key:          id,
+			Size:         m.Size,
+			ETag:         m.ETag,
+			LastModified: info.LastModified,
+			ContentType:  contentType,
+			UserMetadata: opts.UserMetadata,
+			VersionID:    info.VersionID,
+			Backend:      backendID,
+		}, nil
+	}
+
+	if nc := s.namespaces.For(namespace); nc.Storage == StorageDedup {
+		info, m, err := s.putDedup(ctx, client, bucketName, namespace, id, body, size, opts)
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to upload object %q to %q: %w",
+				id, backendID, s.checkBucketError(backendID, bucketName, err))
+		}
+
+		s.releaseManifest(ctx, previous)
+		s.removeErasureHeadCopies(ctx, namespace, id, previous)
+
+		return ObjectInfo{
+			Key:          id,
+			Size:         m.Size,
+			ETag:         m.ETag,
+			LastModified: info.LastModified,
+			ContentType:  contentType,
+			UserMetadata: opts.UserMetadata,
+			VersionID:    info.VersionID,
+			Backend:      backendID,
+		}, nil
+	}
+
+	chunked := s.isChunked(size) && opts.SSEC == nil // chunks are gateway owned objects
+
+	if size < 0 && chunked { // peek first chunk, small streamed bodies are stored as is
+		head, err := io.ReadAll(io.LimitReader(body, s.chunkSize+1))
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+
+		body = io.MultiReader(bytes.NewReader(head), body)
+		if int64(len(head)) <= s.chunkSize {
+			size = int64(len(head))
+		}
+	}
+
+	if chunked && s.isChunked(size) {
+		info, m, err := s.putChunked(ctx, client, bucketName, namespace, id, body, size, opts)
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to upload object %q to %q: %w",
+				id, backendID, s.checkBucketError(backendID, bucketName, err))
+		}
+
+		s.releaseManifest(ctx, previous)
+		s.removeErasureHeadCopies(ctx, namespace, id, previous) // copies of erasure coded object are not overwritten
+
+		return ObjectInfo{
+			Key:          id,
+			Size:         m.Size,
+			ETag:         m.ETag,
+			LastModified: info.LastModified,
+			ContentType:  contentType,
+			UserMetadata: opts.UserMetadata,
+			VersionID:    info.VersionID,
+			Backend:      backendID,
+		}, nil
+	}
+
+	putOpts := minio.PutObjectOptions{
+		ContentType:          contentType,
+		UserMetadata:         opts.Checksums.metadata(opts.UserMetadata),
+		ServerSideEncryption: opts.SSEC,
+	}
+
+	nc := s.namespaces.For(namespace)
+	compression := nc.compression()
+
+	if compression != "" && size > 0 { // original size must be known up front, it is stored as metadata
+		compressed := compressing(body, compression)
+		defer compressed.Close()
+
+		putOpts.UserMetadata[metaCompression] = compression
+		putOpts.UserMetadata[metaSize] = strconv.FormatInt(size, 10)
+
+		body, size = compressed, -1
+	}
+
+	if nc.encrypted() { // plaintext size is derived from stored size
+		body, err = s.seal(ctx, body, putOpts.UserMetadata)
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to encrypt object %q: %w", id, err)
+		}
+
+		size = sealedSize(size)
+	}
+
+	if size < 0 {
+		putOpts.PartSize = internalPartSize // unknown size would otherwise buffer huge parts
+	}
+
+	if etag := opts.Checksums.md5Hex(); etag != "" && (compression != "" || nc.encrypted()) {
+		putOpts.UserMetadata[metaETag] = etag // stored ETag is of transformed bytes
+	}
+
+	info, err := client.PutObject(ctx, bucketName, id, body, size, putOpts)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to upload object %q to %q: %w",
+			id, backendID, s.checkBucketError(backendID, bucketName, err))
+	}
+
+	s.releaseManifest(ctx, previous)
+	s.removeErasureHeadCopies(ctx, namespace, id, previous)
+
+	stored := minio.ObjectInfo{Size: info.Size, ETag: info.ETag, UserMetadata: putOpts.UserMetadata}
+
+	return ObjectInfo{
+		Key:            id,
+		Size:           logicalSize(stored),
+		ETag:           logicalETag(stored),
+		LastModified:   info.LastModified,
+		ContentType:    contentType,
+		UserMetadata:   opts.UserMetadata,
+		VersionID:      info.VersionID,
+		Backend:        backendID,
+		customerKeyMD5: ssecKeyMD5(opts.SSEC),
+	}, nil
+}
+
+func (s *Store) RemoveObject(ctx context.Context, namespace, id string, conditions WriteConditions) (ObjectInfo, error) {
+	_, _, err := s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.lockObject(namespace, id)()
+
+	err = s.checkConditions(ctx, namespace, id, conditions, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return s.removeObject(ctx, namespace, id)
+}
+
+func (s *Store) removeObject(ctx context.Context, namespace, id string) (ObjectInfo, error) { // caller holds key lock
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.invalidateCached(namespace, id)
+
+	bucketName := s.BucketName(namespace)
+
+	previous := s.overwrittenManifest(ctx, client, namespace, id)
+
+	err = client.RemoveObject(ctx, bucketName, id,
+		minio.RemoveObjectOptions{
+			ForceDelete: !s.namespaces.For(namespace).Versioning, // versioned bucket keeps delete marker instead
+		},
+	)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" {
+		return ObjectInfo{}, fmt.Errorf("failed to remove object %q from %q: %w",
+			id, backendID, err)
+	}
+
+	s.releaseManifest(ctx, previous)
+	s.removeErasureHeadCopies(ctx, namespace, id, previous)
+
+	return ObjectInfo{
+		Key:     id,
+		Backend: backendID,
+	}, nil
+}
+
+func (s *Store) StatObject(ctx context.Context, namespace, id string, opts GetOptions) (ObjectInfo, error) {
+	err := s.checkSSEC(namespace, opts.SSEC)
+	if err == nil {
+		err = s.checkVersion(namespace, opts.VersionID)
+	}
+
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := s.statObject(ctx, namespace, id, opts)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) && !errors.Is(err, ErrBucketNotFound) &&
+		s.namespaces.For(namespace).Storage == StorageErasure { // primary owner is down, fall back to manifest copies
+		copyInfo, copyErr := s.statErasureHeadCopy(ctx, namespace, id)
+		if copyErr == nil {
+			log.Printf("Object %q head unavailable, using manifest copy on %q: %v", id, copyInfo.Backend, err)
+
+			info, err = copyInfo, nil
+		}
+	}
+
+	if err == nil {
+		err = s.checkExpired(namespace, &info, opts)
+	}
+
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return info, nil
+}
+
+func (s *Store) checkExpired(namespace string, info *ObjectInfo, opts GetOptions) error { // noncurrent versions are served as stored
+	if opts.VersionID == "" && s.applyExpiry(namespace, info) {
+		return fmt.Errorf("object %q expired at %s: %w", info.Key, info.Expires.Format(time.RFC3339), ErrObjectNotFound)
+	}
+
+	return nil
+}
+
+func (s *Store) statObject(ctx context.Context, namespace, id string, opts GetOptions) (ObjectInfo, error) {
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	exists, err := s.bucketExists(ctx, BackendDef{MinioClient: client, Name: backendID}, bucketName)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to check S3 bucket %q existance on %q: %w",
+			bucketName, backendID, err)
+	}
+
+	if !exists {
+		return ObjectInfo{}, fmt.Errorf("bucket %q not found on %q: %w",
+			bucketName, backendID, ErrBucketNotFound)
+	}
+
+	info, err := client.StatObject(ctx, bucketName, id, opts.backendOptions())
+	if err != nil {
+		return ObjectInfo{}, objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+	}
+
+	return objectInfoFromMinio(info, backendID), nil
+}
+
+func (s *Store) invalidateCached(namespace, id string) { // must be called after backend was changed
+	s.cache.invalidate(namespace, id)
+	s.disk.invalidate(namespace, id)
+}
+
+func (s *Store) GetObject(ctx context.Context, namespace, id string, opts GetOptions) (*ObjectReader, error) {
+	object, err := s.readObject(ctx, namespace, id, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkExpired(namespace, &object.Info, opts)
+	if err != nil {
+		object.Close()
+
+		return nil, err
+	}
+
+	return object, nil
+}
+
+func (s *Store) readObject(ctx context.Context, namespace, id string, opts GetOptions) (*ObjectReader, error) {
+	if opts.SSEC != nil || opts.VersionID != "" { // plaintext of customer keyed objects must not be kept, caches hold current versions
+		err := s.checkSSEC(namespace, opts.SSEC)
+		if err == nil {
+			err = s.checkVersion(namespace, opts.VersionID)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.openObject(ctx, namespace, id, opts)
+	}
+
+	br := opts.Range
+
+	if entry, ok := s.cache.get(namespace, id); ok {
+		return entry.reader(br)
+	}
+
+	if s.disk.has(namespace, id) { // cached file must be validated by ETag first
+		return s.getObjectValidated(ctx, namespace, id, opts)
+	}
+
+	fill := s.cache.startFill(namespace, id)
+
+	object, err := s.openObject(ctx, namespace, id, opts)
+	if err != nil {
+		s.cache.finishFill(fill, namespace, id, ObjectInfo{}, nil)
+
+		return nil, err
+	}
+
+	info := object.Info
+
+	if !s.cache.cacheable(info.Size) || !object.whole() {
+		s.cache.finishFill(fill, namespace, id, info, nil)
+
+		if s.disk.cacheable(info.Size) {
+			s.disk.tee(namespace, id, object) // only whole object reads fill disk cache
+		}
+
+		return object, nil
+	}
+
+	data, err := io.ReadAll(object)
+	object.Close()
+
+	if err != nil {
+		s.cache.finishFill(fill, namespace, id, info, nil)
+
+		return nil, err
+	}
+
+	entry := &objectCacheEntry{info: info, data: data}
+
+	if expected, ok := expectedReadChecksums(info); int64(len(data)) != info.Size || ok && !entry.verify(expected) {
+		log.Printf("Object %q served by %q does not match stored checksum, not caching", id, info.Backend)
+
+		data = nil
+	}
+
+	s.cache.finishFill(fill, namespace, id, info, data)
+
+	return entry.reader(nil)
+}
+
+func (s *Store) openObject(ctx context.Context, namespace, id string, getOpts GetOptions) (*ObjectReader, error) { // info and data come from single backend request
+	br := getOpts.Range
+
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	opts := getOpts.backendOptions()
+	if br != nil {
+		opts.Set("Range", br.String())
+	}
+
+	body, minioInfo, header, err := minio.Core{Client: client}.GetObject(ctx, bucketName, id, opts)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "InvalidRange" { // range may be valid for manifest or original bytes, but not for stored ones
+			info, statErr := s.StatObject(ctx, namespace, id, getOpts)
+			if statErr != nil || (info.layout != layoutManifest && info.compression == "" && info.envelope == nil) {
+				return nil, ErrInvalidRange
+			}
+
+			return s.getObject(ctx, namespace, id, info, getOpts)
+		}
+
+		err = objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+
+		if !errors.Is(err, ErrObjectNotFound) && !errors.Is(err, ErrBucketNotFound) &&
+			s.namespaces.For(namespace).Storage == StorageErasure { // primary owner is down, fall back to manifest copies
+			info, statErr := s.StatObject(ctx, namespace, id, getOpts)
+			if statErr == nil {
+				return s.getObject(ctx, namespace, id, info, getOpts)
+			}
+		}
+
+		return nil, err
+	}
+
+	info := objectInfoFromMinio(minioInfo, backendID)
+
+	if info.layout == layoutManifest {
+		if br != nil { // ranged read returned part of manifest
+			body.Close()
+
+			return s.getObject(ctx, namespace, id, info, getOpts)
+		}
+
+		m, err := decodeManifest(body, id)
+		body.Close()
+
+		if err != nil {
+			return nil, objectError(err, id, backendID)
+		}
+
+		return s.manifestObject(ctx, m, info, br)
+	}
+
+	if info.compression != "" {
+		if br != nil { // ranged read returned part of compressed stream
+			body.Close()
+
+			return s.getObject(ctx, namespace, id, info, getOpts)
+		}
+
+		if acceptsEncoding(getOpts.AcceptEncoding, info.compression) {
+			return &ObjectReader{
+				ReadCloser:      body,
+				Info:            info,
+				Length:          minioInfo.Size,
+				ContentEncoding: info.compression,
+			}, nil
+		}
+
+		return s.decompressedObject(body, info, nil)
+	}
+
+	if info.envelope != nil {
+		if br != nil { // ranged read returned sealed bytes of wrong chunks
+			body.Close()
+
+			if contentRange := header.Get("Content-Range"); contentRange != "" { // plaintext size is derived from whole stored size
+				_, _, stored, err := ParseContentRange(contentRange)
+				if err != nil {
+					return nil, fmt.Errorf("invalid response of %q: %w", backendID, err)
+				}
+
+				info.Size = openedSize(stored)
+			}
+
+			return s.getObject(ctx, namespace, id, info, getOpts)
+		}
+
+		return s.decryptedObject(ctx, body, info, 0, info.Size)
+	}
+
+	offset, length := int64(0), info.Size
+
+	if contentRange := header.Get("Content-Range"); br != nil && contentRange != "" { // backend may answer range with whole object
+		offset, length, info.Size, err = ParseContentRange(contentRange)
+		if err != nil {
+			body.Close()
+
+			return nil, fmt.Errorf("invalid response of %q: %w", backendID, err)
+		}
+	}
+
+	return &ObjectReader{
+		ReadCloser: body,
+		Info:       info,
+		Offset:     offset,
+		Length:     length,
+	}, nil
+}
+
+func (s *Store) getObjectValidated(ctx context.Context, namespace, id string, opts GetOptions) (*ObjectReader, error) {
+	info, err := s.StatObject(ctx, namespace, id, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := s.disk.open(namespace, id, info.ETag); ok {
+		return s.disk.reader(f, info, opts.Range)
+	}
+
+	object, err := s.getObject(ctx, namespace, id, info, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.disk.cacheable(info.Size) {
+		s.disk.tee(namespace, id, object)
+	}
+
+	return object, nil
+}
+
+func (s *Store) getObject(ctx context.Context, namespace, id string, info ObjectInfo, getOpts GetOptions) (*ObjectReader, error) { // for already known info
+	br := getOpts.Range
+
+	offset, length, err := br.Resolve(info.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	client, _, err := s.locate(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.layout == layoutManifest {
+		var m *Manifest
+
+		if info.headCopy {
+			m, err = s.readErasureHeadCopy(ctx, namespace, id, info)
+		} else {
+			m, err = s.readManifest(ctx, client, s.BucketName(namespace), id, getOpts.VersionID)
+		}
+
+		if err != nil {
+			return nil, objectError(err, id, info.Backend)
+		}
+
+		return s.manifestObject(ctx, m, info, br)
+	}
+
+	if info.compression != "" {
+		object, err := client.GetObject(ctx, s.BucketName(namespace), id, getOpts.backendOptions())
+		if err != nil {
+			return nil, objectError(err, id, info.Backend)
+		}
+
+		return s.decompressedObject(object, info, br)
+	}
+
+	start, n := offset, length
+	if info.envelope != nil {
+		start, n = sealedRange(info.Size, offset, length)
+	}
+
+	opts := getOpts.backendOptions()
+	if (br != nil || info.envelope != nil) && n > 0 {
+		err = opts.SetRange(start, start+n-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	object, err := client.GetObject(ctx, s.BucketName(namespace), id, opts)
+	if err != nil {
+		return nil, objectError(err, id, info.Backend)
+	}
+
+	if info.envelope != nil {
+		return s.decryptedObject(ctx, object, info, offset, length)
+	}
+
+	return &ObjectReader{
+		ReadCloser: object,
+		Info:       info,
+		Offset:     offset,
+		Length:     length,
+	}, nil
+}
+
+func (s *Store) decompressedObject(body io.ReadCloser, info ObjectInfo, br *ByteRange) (*ObjectReader, error) {
+	offset, length, err := br.Resolve(info.Size)
+	if err != nil {
+		body.Close()
+
+		return nil, err
+	}
+
+	rc, err := decompressing(body, info.compression, offset, length)
+	if err != nil {
+		return nil, objectError(err, info.Key, info.Backend)
+	}
+
+	return &ObjectReader{
+		ReadCloser: rc,
+		Info:       info,
+		Offset:     offset,
+		Length:     length,
+	}, nil
+}
+
+func (s *Store) decryptedObject(ctx context.Context, body io.ReadCloser, info ObjectInfo, offset, length int64) (*ObjectReader, error) { // body starts at sealed range of offset
+	rc, err := s.open(ctx, info.envelope, body, info.Size, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt object %q: %w", info.Key, err)
+	}
+
+	return &ObjectReader{
+		ReadCloser: rc,
+		Info:       info,
+		Offset:     offset,
+		Length:     length,
+	}, nil
+}
+
+func (s *Store) manifestObject(ctx context.Context, m *Manifest, info ObjectInfo, br *ByteRange) (*ObjectReader, error) {
+	offset, length, err := br.Resolve(info.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	var rc io.ReadCloser
+
+	switch {
+	case m.Erasure != nil:
+		rc, err = s.newErasureReader(ctx, m, offset, length)
+		if err != nil {
+			return nil, err
+		}
+	case m.ChunkSize > 0 && s.chunkPrefetch > 1: // multipart parts may be huge and are streamed instead
+		rc = s.newPrefetchReader(ctx, m, offset, length, s.chunkPrefetch)
+	default:
+		rc = s.newManifestReader(ctx, m, offset, length)
+	}
+
+	return &ObjectReader{
+		ReadCloser: rc,
+		Info:       info,
+		Offset:     offset,
+		Length:     length,
+	}, nil
+}
+
+func (s *Store) ListObjects(ctx context.Context, namespace, prefix, startAfter string, limit int) ([]ObjectInfo, bool, error) { // expired objects are hidden until swept
+	for {
+		objects, truncated, err := s.listObjects(ctx, namespace, prefix, startAfter, limit)
+		if err != nil {
+			return nil, false, err
+		}
+
+		out := objects[:0]
+
+		for _, info := range objects {
+			if !s.applyExpiry(namespace, &info) {
+				out = append(out, info)
+			}
+		}
+
+		if len(out) > 0 || !truncated { // empty truncated page would end client's listing
+			return out, truncated, nil
+		}
+
+		startAfter = objects[len(objects)-1].Key
+	}
+}
+
+func (s *Store) listObjects(ctx context.Context, namespace, prefix, startAfter string, limit int) ([]ObjectInfo, bool, error) {
+	backendDefs := s.backends.GetMembers()
+	if len(backendDefs) == 0 {
+		return nil, false, ErrListBackendsFailure
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	var wg sync.WaitGroup
+
+	results := make([]workerResult, len(backendDefs))
+
+	for i, bDef := range backendDefs {
+		wg.Add(1)
+
+		go s.worker(ctx, &wg, &results[i], bDef, bucketName, prefix, startAfter, limit)
+	}
+
+	wg.Wait()
+
+	seen := make(map[string]struct{})
+	out := make([]ObjectInfo, 0)
+	truncated := false
+
+	for i, res := range results {
+		if res.err != nil {
+			return nil, false, fmt.Errorf("failed to list keys in S3 backend on %q: %w",
+				backendDefs[i].Name, res.err)
+		}
+
+		truncated = truncated || res.truncated
+
+		for _, info := range res.objects {
+			if _, ok := seen[info.Key]; ok {
+				continue
+			}
+
+			seen[info.Key] = struct{}{}
+			out = append(out, info)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+
+	if limit > 0 && len(out) > limit { // first limit keys of merged listing are always complete
+		out = out[:limit]
+		truncated = true
+	}
+
+	return out, truncated, nil
+}
+
+type workerResult struct {
+	objects   []ObjectInfo
+	truncated bool
+	err       error
+}
+
+func (s *Store) worker(ctx context.Context, wg *sync.WaitGroup, res *workerResult, bDef BackendDef, bucketName, prefix, startAfter string, limit int) {
+	defer wg.Done()
+
+	exists, err := s.bucketExists(ctx, bDef, bucketName)
+	if err != nil {
+		res.err = err
+
+		return
+	}
+
+	if !exists { // buckets are created on demand, so member without bucket has no keys
+		return
+	}
+
+	objects, err := ListObjectsInBucket(ctx, bDef.MinioClient, bucketName, prefix, startAfter, limit)
+	if err != nil {
+		res.err = s.checkBucketError(bDef.Name, bucketName, err)
+
+		return
+	}
+
+	res.objects = make([]ObjectInfo, 0, len(objects))
+	res.truncated = limit > 0 && len(objects) == limit
+
+	for _, object := range objects {
+		res.objects = append(res.objects, objectInfoFromMinio(object, bDef.Name))
+	}
+
+	log.Printf("Processing keys from %q", bDef.Name)
+}
+
+func (s *Store) CreateNamespace(ctx context.Context, namespace string) error {
+	bucketName := s.BucketName(namespace)
+
+	for _, bDef := range s.backends.GetMembers() {
+		err := s.ensureBucket(ctx, bDef, bucketName)
+		if err != nil {
+			return fmt.Errorf("failed to ensure S3 bucket %q existance on %q: %w",
+				bucketName, bDef.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) NamespaceExists(ctx context.Context, namespace string) (bool, error) {
+	bucketName := s.BucketName(namespace)
+
+	for _, bDef := range s.backends.GetMembers() {
+		exists, err := s.bucketExists(ctx, bDef, bucketName)
+		if err != nil {
+			return false, fmt.Errorf("failed to check S3 bucket %q existance on %q: %w",
+				bucketName, bDef.Name, err)
+		}
+
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+type NamespaceInfo struct {
+	Name         string
+	CreationDate time.Time
+}
+
+func (s *Store) bucketNamespace(bucketName string) (string, bool) { // reverse of BucketName, false for foreign buckets
+	prefix := os.Getenv(S3NamespaceBucketPrefixEnvKey)
+
+	switch {
+	case bucketName == s.defaultBucketName:
+		return DefaultNamespace, true
+	case strings.HasPrefix(bucketName, prefix):
+		namespace := strings.TrimPrefix(bucketName, prefix)
+
+		return namespace, IsValidNamespace(namespace)
+	default:
+		return "", false
+	}
+}
+
+func (s *Store) ListNamespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	namespaces := map[string]time.Time{
+		DefaultNamespace: {},
+	}
+
+	for _, bDef := range s.backends.GetMembers() {
+		buckets, err := bDef.MinioClient.ListBuckets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 buckets on %q: %w", bDef.Name, err)
+		}
+
+		for _, bucket := range buckets {
+			namespace, ok := s.bucketNamespace(bucket.Name)
+			if !ok {
+				continue
+			}
+
+			created, ok := namespaces[namespace]
+			if !ok || created.IsZero() || bucket.CreationDate.Before(created) { // earliest bucket defines namespace age
+				namespaces[namespace] = bucket.CreationDate
+			}
+		}
+	}
+
+	out := make([]NamespaceInfo, 0, len(namespaces))
+	for namespace, created := range namespaces {
+		out = append(out, NamespaceInfo{
+			Name:         namespace,
+			CreationDate: created,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+func (s *Store) DeleteNamespace(ctx context.Context, namespace string) error {
+	if namespace == DefaultNamespace {
+		return ErrNamespaceProtected
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	found := false
+	backendDefs := s.backends.GetMembers()
+
+	for _, bDef := range backendDefs { // check all members first, so that non-empty namespace stays intact
+		exists, err := bDef.MinioClient.BucketExists(ctx, bucketName)
+		if err != nil {
+			return fmt.Errorf("failed to check S3 bucket %q existance on %q: %w",
+				bucketName, bDef.Name, err)
+		}
+
+		if !exists {
+			continue
+		}
+
+		found = true
+
+		empty, err := IsBucketEmpty(ctx, bDef.MinioClient, bucketName)
+		if err != nil {
+			return fmt.Errorf("failed to list keys in S3 backend on %q: %w", bDef.Name, err)
+		}
+
+		if !empty {
+			return fmt.Errorf("namespace %q: %w", namespace, ErrNamespaceNotEmpty)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("namespace %q: %w", namespace, ErrNamespaceNotFound)
+	}
+
+	for _, bDef := range backendDefs {
+		err := bDef.MinioClient.RemoveBucket(ctx, bucketName)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" {
+			return fmt.Errorf("failed to remove S3 bucket %q from %q: %w",
+				bucketName, bDef.Name, err)
+		}
+
+		s.buckets.forget(bDef.Name, bucketName)
+	}
+
+	return nil
+}
+
+func objectInfoFromMinio(info minio.ObjectInfo, backendID string) ObjectInfo {
+	return ObjectInfo{
+		Key:          info.Key,
+		Size:         logicalSize(info),
+		ETag:         logicalETag(info),
+		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+		UserMetadata: publicMetadata(info.UserMetadata),
+		Checksums:    checksumsFromMetadata(info.UserMetadata),
+		VersionID:    info.VersionID,
+		Expires:      expiresFromMetadata(info.UserMetadata),
+		Tags:         info.UserTags,
+		Backend:      backendID,
+		layout:       metaValue(info.UserMetadata, metaLayout),
+		compression:  metaValue(info.UserMetadata, metaCompression),
+		envelope:     envelopeFromMetadata(info.UserMetadata),
+		headETag:     info.ETag,
+
+		customerKeyMD5: info.Metadata.Get(headerSSECKeyMD5),
+	}
+}
+
+func (s *Store) previousManifest(ctx context.Context, client *minio.Client, bucketName, id string) *Manifest { // parts of overwritten object must be released
+	info, err := client.StatObject(ctx, bucketName, id, minio.GetObjectOptions{})
+	if err != nil || metaValue(info.UserMetadata, metaLayout) != layoutManifest {
+		return nil
+	}
+
+	m, err := s.readManifest(ctx, client, bucketName, id, "")
+	if err != nil {
+		log.Printf("Failed to read previous manifest of %q, its parts are left for scrubber: %v", id, err)
+
+		return nil
+	}
+
+	return m
+}
+
+func (s *Store) releaseManifest(ctx context.Context, m *Manifest) {
+	if m == nil {
+		return
+	}
+
+	err := s.removeManifestParts(ctx, m)
+	if err != nil {
+		log.Printf("Failed to remove parts of overwritten manifest, they are left for scrubber: %v", err)
+	}
+}
+
+func (s *Store) putInternal(ctx context.Context, ringKey, key string, body io.Reader, size int64, userMetadata map[string]string) (minio.UploadInfo, string, error) {
+	client, backendID := s.backends.Locate(ringKey)
+	if client == nil {
+		return minio.UploadInfo{}, "", ErrNoBackend
+	}
+
+	info, err := s.putInternalAt(ctx, BackendDef{MinioClient: client, Name: backendID}, key, body, size, userMetadata)
+
+	return info, backendID, err
+}
+
+func (s *Store) putInternalAt(ctx context.Context, bDef BackendDef, key string, body io.Reader, size int64, userMetadata map[string]string) (minio.UploadInfo, error) {
+	err := s.ensureBucket(ctx, bDef, s.internalBucketName)
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("failed to ensure S3 bucket %q existance on %q: %w",
+			s.internalBucketName, bDef.Name, err)
+	}
+
+	info, err := bDef.MinioClient.PutObject(ctx, s.internalBucketName, key, body, size,
+		minio.PutObjectOptions{
+			ContentType:  "application/octet-stream",
+			UserMetadata: userMetadata,
+			PartSize:     internalPartSize, // unknown size would otherwise buffer huge parts
+		},
+	)
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("failed to upload internal object %q to %q: %w",
+			key, bDef.Name, s.checkBucketError(bDef.Name, s.internalBucketName, err))
+	}
+
+	return info, nil
+}
+
+func (s *Store) getInternal(ctx context.Context, ringKey, key string, offset, length int64) (io.ReadCloser, error) {
+	client, backendID := s.backends.Locate(ringKey)
+	if client == nil {
+		return nil, ErrNoBackend
+	}
+
+	return s.getInternalAt(ctx, BackendDef{MinioClient: client, Name: backendID}, key, offset, length)
+}
+
+func (s *Store) getInternalAt(ctx context.Context, bDef BackendDef, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if length > 0 {
+		err := opts.SetRange(offset, offset+length-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	object, err := bDef.MinioClient.GetObject(ctx, s.internalBucketName, key, opts)
+	if err != nil {
+		return nil, objectError(err, key, bDef.Name)
+	}
+
+	return object, nil
+}
+
+func (s *Store) statInternal(ctx context.Context, ringKey, key string) (minio.ObjectInfo, error) {
+	client, backendID := s.backends.Locate(ringKey)
+	if client == nil {
+		return minio.ObjectInfo{}, ErrNoBackend
+	}
+
+	return s.statInternalAt(ctx, BackendDef{MinioClient: client, Name: backendID}, key)
+}
+
+func (s *Store) statInternalAt(ctx context.Context, bDef BackendDef, key string) (minio.ObjectInfo, error) {
+	info, err := bDef.MinioClient.StatObject(ctx, s.internalBucketName, key, minio.GetObjectOptions{})
+	if err != nil {
+		return minio.ObjectInfo{}, objectError(err, key, bDef.Name)
+	}
+
+	return info, nil
+}
+
+func (s *Store) removeInternal(ctx context.Context, ringKey, key string) error {
+	client, backendID := s.backends.Locate(ringKey)
+	if client == nil {
+		return ErrNoBackend
+	}
+
+	return s.removeInternalAt(ctx, BackendDef{MinioClient: client, Name: backendID}, key)
+}
+
+func (s *Store) removeInternalAt(ctx context.Context, bDef BackendDef, key string) error {
+	err := bDef.MinioClient.RemoveObject(ctx, s.internalBucketName, key, minio.RemoveObjectOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" {
+		return fmt.Errorf("failed to remove internal object %q from %q: %w", key, bDef.Name, err)
+	}
+
+	return nil
+}
+
+type internalObject struct {
+	minio.ObjectInfo
+
+	Backend BackendDef
+}
+
+func (s *Store) listInternal(ctx context.Context, prefix string) ([]internalObject, error) { // fan-out over all members
+	out := make([]internalObject, 0)
+
+	for _, bDef := range s.backends.GetMembers() {
+		exists, err := s.bucketExists(ctx, bDef, s.internalBucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check S3 bucket %q existance on %q: %w",
+				s.internalBucketName, bDef.Name, err)
+		}
+
+		if !exists {
+			continue
+		}
+
+		objects, err := ListObjectsInBucket(ctx, bDef.MinioClient, s.internalBucketName, prefix, "", 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list keys in S3 backend on %q: %w", bDef.Name, err)
+		}
+
+		for _, object := range objects {
+			out = append(out, internalObject{
+				ObjectInfo: object,
+				Backend:    bDef,
+			})
+		}
+	}
+
+	return out, nil
+}
+
+func objectError(err error, id, backendID string) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFoundObject", "NoSuchVersion", "MethodNotAllowed": // delete marker can not be read by version
+		return fmt.Errorf("object %q not found on %q: %w", id, backendID, ErrObjectNotFound)
+	case "NoSuchBucket":
+		return fmt.Errorf("object %q not found on %q: %w", id, backendID, ErrBucketNotFound)
+	case "AccessDenied": // also wrong customer key
+		return fmt.Errorf("object %q on %q: %w", id, backendID, ErrAccessDenied)
+	case "InvalidRequest":
+		return fmt.Errorf("object %q on %q: %w", id, backendID, ErrSSECMismatch)
+	default:
+		return fmt.Errorf("internal server error: %w", err)
+	}
+}
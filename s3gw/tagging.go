@@ -0,0 +1,199 @@
+package s3gw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+const maxTaggingBodySize = 64 << 10 // 10 tags of 128 byte keys and 256 byte values fit many times
+
+var ErrInvalidTags = errors.New("invalid tags")
+
+func validateTags(m map[string]string) (*tags.Tags, error) { // S3 object limits, 10 tags, 128 character keys, 256 character values
+	t, err := tags.MapToObjectTags(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTags, err)
+	}
+
+	return t, nil
+}
+
+func (s *Store) PutObjectTags(ctx context.Context, namespace, id string, m map[string]string) error { // replaces whole tag set
+	t, err := validateTags(m)
+	if err != nil {
+		return err
+	}
+
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return err
+	}
+
+	defer s.lockObject(namespace, id)() // tags must not be set on object being replaced
+
+	_, err = s.StatObject(ctx, namespace, id, GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	err = client.PutObjectTagging(ctx, bucketName, id, t, minio.PutObjectTaggingOptions{})
+	if err != nil {
+		return objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+	}
+
+	return nil
+}
+
+func (s *Store) GetObjectTags(ctx context.Context, namespace, id string) (map[string]string, error) {
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.StatObject(ctx, namespace, id, GetOptions{}) // expired objects have no tags to show
+	if err != nil {
+		return nil, err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	t, err := client.GetObjectTagging(ctx, bucketName, id, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+	}
+
+	return t.ToMap(), nil
+}
+
+func (s *Store) RemoveObjectTags(ctx context.Context, namespace, id string) error {
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return err
+	}
+
+	defer s.lockObject(namespace, id)()
+
+	_, err = s.StatObject(ctx, namespace, id, GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	err = client.RemoveObjectTagging(ctx, bucketName, id, minio.RemoveObjectTaggingOptions{})
+	if err != nil {
+		return objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+	}
+
+	return nil
+}
+
+type TagFilter []tagCondition // all conditions must hold
+
+type tagCondition struct {
+	key      string
+	value    string
+	anyValue bool
+}
+
+func TagFilterFromQuery(values []string) TagFilter { // "key=value" matches value, "key" matches any value
+	filter := make(TagFilter, 0, len(values))
+
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		filter = append(filter, tagCondition{key: key, value: value, anyValue: !ok})
+	}
+
+	return filter
+}
+
+func (f TagFilter) Matches(t map[string]string) bool {
+	for _, c := range f {
+		value, ok := t[c.key]
+		if !ok || !c.anyValue && value != c.value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func HandleObjectTagsPut(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	defer r.Body.Close()
+
+	var m map[string]string
+
+	err := json.NewDecoder(io.LimitReader(r.Body, maxTaggingBodySize)).Decode(&m)
+	if err != nil {
+		http.Error(w,
+			"Invalid tags, must be JSON object of string values",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	err = store.PutObjectTags(r.Context(), namespace, id, m)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("Set %d tags of object %q", len(m), id)
+}
+
+func HandleObjectTagsGet(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	m, err := store.GetObjectTags(r.Context(), namespace, id)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(m)
+	if err != nil {
+		log.Printf("Failed to write tags of object %q: %v", id, err)
+	}
+}
+
+func HandleObjectTagsDelete(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace, id, ok := getObjectRef(w, r)
+	if !ok {
+		return
+	}
+
+	err := store.RemoveObjectTags(r.Context(), namespace, id)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("Removed tags of object %q", id)
+}
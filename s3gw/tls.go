@@ -0,0 +1,228 @@
+package s3gw
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	TLSCertFileEnvKey       = "TLS_CERT_FILE" // empty serves plain HTTP
+	TLSKeyFileEnvKey        = "TLS_KEY_FILE"
+	TLSClientCAFileEnvKey   = "TLS_CLIENT_CA_FILE"  // non-empty requires client certificates signed by bundle (mTLS)
+	TLSReloadIntervalEnvKey = "TLS_RELOAD_INTERVAL" // files are reloaded when changed, 0 disables
+)
+
+var errNoCertificates = errors.New("no PEM certificates found")
+
+type CertReloader struct { // serves current certificate, client CA bundle is reloaded along with it
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+}
+
+func LoadListenerTLS() (*CertReloader, error) { // nil when listener serves plain HTTP
+	cr := &CertReloader{
+		certFile:     os.Getenv(TLSCertFileEnvKey),
+		keyFile:      os.Getenv(TLSKeyFileEnvKey),
+		clientCAFile: os.Getenv(TLSClientCAFileEnvKey),
+	}
+
+	if cr.certFile == "" && cr.keyFile == "" {
+		if cr.clientCAFile != "" {
+			return nil, fmt.Errorf("client CA bundle requires listener certificate and key")
+		}
+
+		return nil, nil
+	}
+
+	if cr.certFile == "" || cr.keyFile == "" {
+		return nil, fmt.Errorf("listener TLS requires both certificate and key file")
+	}
+
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+func (cr *CertReloader) files() []string {
+	files := []string{cr.certFile, cr.keyFile}
+	if cr.clientCAFile != "" {
+		files = append(files, cr.clientCAFile)
+	}
+
+	return files
+}
+
+func (cr *CertReloader) changed() bool {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	for i, name := range cr.files() {
+		fi, err := os.Stat(name)
+		if err != nil || !fi.ModTime().Equal(cr.modTimes[i]) { // failed stat is reported by reload
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cr *CertReloader) reload() error {
+	modTimes := make([]time.Time, 0, 3)
+
+	for _, name := range cr.files() { // taken before reading, write during reload is picked up next time
+		fi, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS file: %w", err)
+		}
+
+		modTimes = append(modTimes, fi.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load listener certificate %q: %w", cr.certFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+
+	if cr.clientCAFile != "" {
+		clientCAs, err = loadCertPool(x509.NewCertPool(), cr.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client CA bundle: %w", err)
+		}
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.cert = &cert
+	cr.clientCAs = clientCAs
+	cr.modTimes = modTimes
+
+	return nil
+}
+
+func (cr *CertReloader) Run(ctx context.Context) {
+	interval := MustGetDurationFromEnv(TLSReloadIntervalEnvKey)
+	if cr == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !cr.changed() {
+			continue
+		}
+
+		err := cr.reload()
+		if err != nil { // files may be half written, previous certificate is kept
+			log.Printf("Failed to reload listener TLS files, keeping previous: %v", err)
+
+			continue
+		}
+
+		log.Printf("Reloaded listener certificate from %q", cr.certFile)
+	}
+}
+
+func (cr *CertReloader) TLSConfig() *tls.Config { // nil when listener serves plain HTTP
+	if cr == nil {
+		return nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.certificate,
+	}
+
+	if cr.clientCAFile != "" {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) { // per handshake, bundle may have been reloaded
+			cr.mu.RLock()
+			defer cr.mu.RUnlock()
+
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: cr.certificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      cr.clientCAs,
+			}, nil
+		}
+	}
+
+	return cfg
+}
+
+func (cr *CertReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
+
+func backendTLSConfig(secure bool) (*tls.Config, error) { // nil keeps transport defaults
+	caFile, serverName := os.Getenv(S3BackendCAFileEnvKey), os.Getenv(S3BackendServerNameEnvKey)
+	if caFile == "" && serverName == "" {
+		return nil, nil
+	}
+
+	if !secure {
+		return nil, fmt.Errorf("backend CA bundle and server name require backend TLS")
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName, // backends are dialed by container address
+	}
+
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		cfg.RootCAs, err = loadCertPool(pool, caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend CA bundle: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadCertPool(pool *x509.CertPool, name string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%q: %w", name, errNoCertificates)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,206 @@
+package s3gw
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	err := os.WriteFile(name, data, 0o600)
+	if err == nil {
+		err = os.Chtimes(name, modTime, modTime) // coarse file system clocks would hide rewrite
+	}
+
+	if err != nil {
+		t.Fatalf("Failed to write %q: %v", name, err)
+	}
+}
+
+func TestListenerTLSReloadAndClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	now := time.Now()
+
+	certPEM, keyPEM := ca.issue(t, "first", x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, certFile, certPEM, now)
+	writeTestFile(t, keyFile, keyPEM, now)
+	writeTestFile(t, caFile, ca.pem, now)
+
+	t.Setenv(TLSCertFileEnvKey, certFile)
+	t.Setenv(TLSKeyFileEnvKey, keyFile)
+	t.Setenv(TLSClientCAFileEnvKey, caFile)
+
+	cr, err := LoadListenerTLS()
+	if err != nil {
+		t.Fatalf("Failed to load listener TLS: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cr.TLSConfig())
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(ln)
+
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	clientCertPEM, clientKeyPEM := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+
+	servedName := func(certs ...tls.Certificate) (string, error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, Certificates: certs})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		err = conn.Handshake() // client certificate is rejected after client side handshake completes
+		if err == nil {
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		}
+
+		if err == nil {
+			_, err = conn.Read(make([]byte, 1))
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	if name, err := servedName(clientCert); err != nil || name != "first" {
+		t.Fatalf("Expected first certificate to be served, got %q: %v", name, err)
+	}
+
+	if _, err := servedName(); err == nil {
+		t.Errorf("Expected connection without client certificate to be rejected")
+	}
+
+	if cr.changed() {
+		t.Errorf("Expected unchanged files not to be reloaded")
+	}
+
+	certPEM, keyPEM = ca.issue(t, "second", x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, certFile, certPEM, now.Add(time.Second))
+	writeTestFile(t, keyFile, keyPEM, now.Add(time.Second))
+
+	if !cr.changed() {
+		t.Fatalf("Expected rewritten files to be detected")
+	}
+
+	err = cr.reload()
+	if err != nil {
+		t.Fatalf("Failed to reload listener TLS: %v", err)
+	}
+
+	if name, err := servedName(clientCert); err != nil || name != "second" {
+		t.Fatalf("Expected reloaded certificate to be served, got %q: %v", name, err)
+	}
+
+	writeTestFile(t, keyFile, []byte("garbage"), now.Add(2*time.Second))
+
+	if err := cr.reload(); err == nil {
+		t.Errorf("Expected invalid key to fail reload")
+	}
+
+	if name, err := servedName(clientCert); err != nil || name != "second" {
+		t.Errorf("Expected previous certificate to be kept after failed reload, got %q: %v", name, err)
+	}
+}
@@ -0,0 +1,253 @@
+package s3gw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const headerVersionID = "X-Amz-Version-Id"
+
+var ErrVersioningDisabled = errors.New("versioning is not enabled for namespace")
+
+type ObjectVersion struct {
+	VersionID      string    `json:"versionId"`
+	IsLatest       bool      `json:"isLatest"`
+	IsDeleteMarker bool      `json:"isDeleteMarker"`
+	Size           int64     `json:"size"`
+	ETag           string    `json:"etag,omitempty"`
+	LastModified   time.Time `json:"lastModified"`
+}
+
+func (s *Store) checkVersion(namespace, versionID string) error {
+	if versionID != "" && !s.namespaces.For(namespace).Versioning {
+		return fmt.Errorf("namespace %q: %w", namespace, ErrVersioningDisabled)
+	}
+
+	return nil
+}
+
+func (s *Store) enableVersioning(ctx context.Context, bDef BackendDef, bucketName string) error { // no-op for buckets of unversioned namespaces
+	namespace, ok := s.bucketNamespace(bucketName)
+	if !ok || !s.namespaces.For(namespace).Versioning {
+		return nil
+	}
+
+	err := bDef.MinioClient.SetBucketVersioning(ctx, bucketName, minio.BucketVersioningConfiguration{Status: minio.Enabled})
+	if err != nil {
+		return fmt.Errorf("failed to enable versioning of S3 bucket %q on %q: %w", bucketName, bDef.Name, err)
+	}
+
+	return nil
+}
+
+func (s *Store) overwrittenManifest(ctx context.Context, client *minio.Client, namespace, id string) *Manifest { // nil when replaced version is kept
+	if s.namespaces.For(namespace).Versioning {
+		return nil
+	}
+
+	return s.previousManifest(ctx, client, s.BucketName(namespace), id)
+}
+
+func (s *Store) ListObjectVersions(ctx context.Context, namespace, id string) ([]ObjectVersion, error) { // newest first, delete markers included
+	if !s.namespaces.For(namespace).Versioning {
+		return nil, fmt.Errorf("namespace %q: %w", namespace, ErrVersioningDisabled)
+	}
+
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketName := s.BucketName(namespace)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop listing once keys sorting after id are reached
+
+	versions := make([]ObjectVersion, 0)
+
+	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       id,
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, objectError(s.checkBucketError(backendID, bucketName, object.Err), id, backendID)
+		}
+
+		if object.Key > id {
+			break
+		}
+
+		if object.Key != id { // other key sharing prefix
+			continue
+		}
+
+		version := ObjectVersion{
+			VersionID:      object.VersionID,
+			IsLatest:       object.IsLatest,
+			IsDeleteMarker: object.IsDeleteMarker,
+			LastModified:   object.LastModified,
+		}
+
+		if !object.IsDeleteMarker {
+			version.Size, version.ETag = logicalSize(object), logicalETag(object)
+		}
+
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("object %q not found on %q: %w", id, backendID, ErrObjectNotFound)
+	}
+
+	return versions, nil
+}
+
+func (s *Store) RestoreObjectVersion(ctx context.Context, namespace, id, versionID string) (ObjectInfo, error) { // old version is copied on top, history is kept
+	defer s.lockObject(namespace, id)()
+
+	source, err := s.StatObject(ctx, namespace, id, GetOptions{VersionID: versionID}) // delete markers can not be restored
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	client, backendID, err := s.locate(namespace, id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	defer s.invalidateCached(namespace, id)
+
+	bucketName := s.BucketName(namespace)
+
+	dst := minio.CopyDestOptions{Bucket: bucketName, Object: id}
+
+	if !source.Expires.IsZero() { // restored version would come back expired, namespace rules apply from restore on
+		stored, err := client.StatObject(ctx, bucketName, id, minio.StatObjectOptions{VersionID: versionID})
+		if err != nil {
+			return ObjectInfo{}, objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+		}
+
+		meta := make(map[string]string, len(stored.UserMetadata)+1)
+		for k, v := range stored.UserMetadata {
+			if metaValue(map[string]string{k: v}, metaExpires) == "" {
+				meta[k] = v
+			}
+		}
+		meta["Content-Type"] = stored.ContentType
+
+		dst.UserMetadata = meta
+		dst.ReplaceMetadata = true
+		source.Expires = time.Time{}
+	}
+
+	info, err := client.CopyObject(ctx, dst,
+		minio.CopySrcOptions{Bucket: bucketName, Object: id, VersionID: versionID},
+	)
+	if err != nil {
+		return ObjectInfo{}, objectError(s.checkBucketError(backendID, bucketName, err), id, backendID)
+	}
+
+	source.VersionID = info.VersionID // manifest heads are copied as is, parts are shared with restored version
+	source.LastModified = info.LastModified
+
+	return source, nil
+}
+
+func writeVersionHeader(w http.ResponseWriter, versionID string) {
+	if versionID != "" {
+		w.Header().Set(headerVersionID, versionID)
+	}
+}
+
+func HandleObjectVersions(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		http.Error(w,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	id := GetID(r, w)
+	if id == "" {
+		http.Error(w,
+			InvalidIDMessage(),
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	versions, err := store.ListObjectVersions(r.Context(), namespace, id)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(versions)
+	if err != nil {
+		log.Printf("Failed to write versions of object %q: %v", id, err)
+	}
+
+	log.Printf("Fetched %d versions of object %q", len(versions), id)
+}
+
+func HandleObjectRestore(w http.ResponseWriter, r *http.Request, store *Store) {
+	namespace := GetNamespace(r)
+	if namespace == "" {
+		http.Error(w,
+			"Invalid namespace, must be lowercase alphanumeric with dashes and 3 to 32 characters",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	id := GetID(r, w)
+	if id == "" {
+		http.Error(w,
+			InvalidIDMessage(),
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	versionID := r.URL.Query().Get("versionId")
+	if versionID == "" {
+		http.Error(w,
+			"Missing version to restore",
+			http.StatusBadRequest,
+		)
+
+		return
+	}
+
+	info, err := store.RestoreObjectVersion(r.Context(), namespace, id, versionID)
+	if err != nil {
+		writeObjectError(w, err)
+
+		return
+	}
+
+	writeVersionHeader(w, info.VersionID)
+	w.Header().Set("ETag", strconv.Quote(info.ETag))
+	w.WriteHeader(http.StatusCreated)
+	log.Printf("Object %q restored from version %q on %q", id, versionID, info.Backend)
+}